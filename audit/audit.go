@@ -0,0 +1,30 @@
+// Package audit writes security-relevant events to the audit log.
+package audit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kostya-zero/blogger/models"
+	"gorm.io/gorm"
+)
+
+const (
+	ActionLoginLockout = "login.lockout"
+	ActionRoleChange   = "user.role_change"
+	ActionSuspend      = "user.suspend"
+	ActionUnsuspend    = "user.unsuspend"
+	ActionAppeal       = "user.appeal"
+
+	ActionImpersonateStart = "user.impersonate_start"
+	ActionImpersonateStop  = "user.impersonate_stop"
+)
+
+// Record writes an entry to the audit log. Failures are reported but never
+// interrupt the request that caused the event.
+func Record(db *gorm.DB, entry models.AuditLog) {
+	entry.CreatedAt = time.Now()
+	if err := db.Create(&entry).Error; err != nil {
+		fmt.Printf("Failed to write audit log entry %q: %s\n", entry.Action, err.Error())
+	}
+}
@@ -0,0 +1,149 @@
+// Command blogger-admin manages Blogger from the server's shell, e.g. to
+// promote the first admin or to let the first users into an instance whose
+// registration is not open:
+//
+//	blogger-admin set-role alice admin
+//	blogger-admin create-invite 10 30
+//
+// After upgrading from a version without publish states and slugs, run
+// "blogger-admin backfill" once to date and address the existing posts.
+//
+// It reads the same environment (and .env file) as the server, which must
+// have run its migrations at least once.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/kostya-zero/blogger/audit"
+	"github.com/kostya-zero/blogger/invites"
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/publishing"
+	"github.com/kostya-zero/blogger/revocation"
+	"github.com/kostya-zero/blogger/roles"
+	"github.com/kostya-zero/blogger/sessions"
+	"github.com/kostya-zero/blogger/slugs"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+const usage = `Usage:
+  blogger-admin set-role <username> <role> [permission...]
+  blogger-admin create-invite [max-uses] [expires-in-days]
+  blogger-admin backfill
+
+Roles: %s
+Permissions: %s
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		exitWithUsage()
+	}
+	switch os.Args[1] {
+	case "set-role":
+		if len(os.Args) < 4 {
+			exitWithUsage()
+		}
+	case "create-invite", "backfill":
+	default:
+		exitWithUsage()
+	}
+
+	godotenv.Load()
+
+	db, err := gorm.Open(postgres.Open(os.Getenv("BLOGGER_GORM_DATABASE_STRING")), &gorm.Config{TranslateError: true})
+	if err != nil {
+		fmt.Printf("Failed to open connection to database: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	switch os.Args[1] {
+	case "set-role":
+		err = setRole(db, os.Args[2], os.Args[3], os.Args[4:])
+	case "create-invite":
+		err = createInvite(db, os.Args[2:])
+	case "backfill":
+		err = backfill(db)
+	}
+	if err != nil {
+		fmt.Printf("Failed to %s: %s\n", strings.ReplaceAll(os.Args[1], "-", " "), err.Error())
+		os.Exit(1)
+	}
+}
+
+func exitWithUsage() {
+	fmt.Fprintf(os.Stderr, usage, strings.Join(roles.Roles, ", "), strings.Join(roles.Permissions, ", "))
+	os.Exit(2)
+}
+
+func setRole(db *gorm.DB, username, role string, extra []string) error {
+	var target models.User
+	if err := db.Where("username = ?", username).First(&target).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("no user named %q", username)
+		}
+		return err
+	}
+
+	// Sessions of a demoted user are revoked in the database; access tokens
+	// already issued stop working on the server's next session check.
+	store := sessions.NewStore(db, 0, revocation.NewPostgresStore(db, time.Hour))
+
+	user, err := roles.Set(db, store, target.ID, role, extra)
+	if err != nil {
+		return err
+	}
+
+	audit.Record(db, models.AuditLog{
+		UserID: &user.ID,
+		Action: audit.ActionRoleChange,
+		Detail: fmt.Sprintf("%s -> %s [%s] via blogger-admin", target.Role, user.Role, user.Permissions),
+	})
+
+	fmt.Printf("%s is now %s with permissions: %s\n", user.Username, user.Role, strings.Join(roles.Of(user), ", "))
+	return nil
+}
+
+// createInvite mints an invite without an inviter. It takes the number of
+// uses and the days it is valid for, one and seven by default.
+func createInvite(db *gorm.DB, args []string) error {
+	numbers := []int{1, 7}
+	for i, arg := range args[:min(len(args), len(numbers))] {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 {
+			return fmt.Errorf("%q is not a positive number", arg)
+		}
+		numbers[i] = n
+	}
+
+	store := &invites.Store{DB: db}
+	expiresAt := time.Now().AddDate(0, 0, numbers[1])
+	code, invite, err := store.Create(nil, numbers[0], &expiresAt)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Invite code: %s\nUses: %d, expires: %s\n", code, invite.MaxUses, expiresAt.Format(time.RFC3339))
+	return nil
+}
+
+// backfill fills in what posts from before publish states and slugs lack. It
+// can be run again safely; posts that have both are left alone.
+func backfill(db *gorm.DB) error {
+	if err := publishing.Backfill(db); err != nil {
+		return err
+	}
+	if err := slugs.Backfill(db); err != nil {
+		return err
+	}
+
+	fmt.Println("Posts have publish dates and slugs")
+	return nil
+}
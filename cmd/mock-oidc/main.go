@@ -0,0 +1,35 @@
+// Command mock-oidc runs the oidctest provider for local development. Point
+// Blogger at it with BLOGGER_OIDC_PROVIDERS=mock,
+// BLOGGER_OIDC_MOCK_ISSUER=http://localhost:9000 and
+// BLOGGER_OIDC_MOCK_CLIENT_ID=blogger.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/kostya-zero/blogger/oidc/oidctest"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:9000", "address to listen on")
+	clientID := flag.String("client-id", "blogger", "client ID to accept")
+	email := flag.String("email", "reader@example.com", "email of the signed in user")
+	subject := flag.String("sub", "1", "subject of the signed in user")
+	flag.Parse()
+
+	provider, err := oidctest.New("http://"+*addr, *clientID)
+	if err != nil {
+		fmt.Printf("Failed to create provider: %s\n", err.Error())
+		os.Exit(1)
+	}
+	provider.SetUser(oidctest.User{Subject: *subject, Email: *email, EmailVerified: true})
+
+	fmt.Printf("Mock OIDC provider listening on http://%s\n", *addr)
+	if err := http.ListenAndServe(*addr, provider); err != nil {
+		fmt.Printf("Error starting provider: %s\n", err.Error())
+		os.Exit(1)
+	}
+}
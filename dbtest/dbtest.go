@@ -0,0 +1,54 @@
+// Package dbtest opens throwaway databases with the schema migrated, so that
+// tests can run without a Postgres server.
+package dbtest
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/kostya-zero/blogger/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var counter atomic.Int64
+
+// Open returns a fresh in-memory SQLite database with every model migrated.
+// Row locks are ignored by SQLite, which serializes writers instead.
+func Open(t testing.TB) *gorm.DB {
+	t.Helper()
+
+	dsn := fmt.Sprintf("file:dbtest%d?mode=memory&cache=shared&_pragma=foreign_keys(1)", counter.Add(1))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		TranslateError: true,
+		Logger:         logger.Discard,
+	})
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+
+	// The models use Postgres' now() as a column default.
+	db.Callback().Raw().Before("gorm:raw").Register("dbtest:now", func(db *gorm.DB) {
+		sql := db.Statement.SQL.String()
+		if strings.Contains(sql, "now()") {
+			db.Statement.SQL.Reset()
+			db.Statement.SQL.WriteString(strings.ReplaceAll(sql, "now()", "(CURRENT_TIMESTAMP)"))
+		}
+	})
+
+	if err := db.AutoMigrate(models.All()...); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("database handle: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	return db
+}
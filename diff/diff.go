@@ -0,0 +1,112 @@
+// Package diff compares texts line by line.
+package diff
+
+import "strings"
+
+type Op string
+
+const (
+	Equal  Op = "equal"
+	Delete Op = "delete"
+	Insert Op = "insert"
+)
+
+// Line is a line of the first text kept or deleted, or a line of the second
+// text inserted.
+type Line struct {
+	Op   Op     `json:"op"`
+	Text string `json:"text"`
+}
+
+// maxCells bounds the table used to find the longest common subsequence of
+// the changed part. Larger changes are reported as deleted and inserted as a
+// whole.
+const maxCells = 4_000_000
+
+// Lines returns the edits that turn a into b, keeping as many lines as
+// possible.
+func Lines(a, b string) []Line {
+	x, y := split(a), split(b)
+
+	prefix := 0
+	for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+		suffix++
+	}
+
+	lines := make([]Line, 0, len(x)+len(y))
+	lines = appendAll(lines, Equal, x[:prefix])
+	lines = appendChanged(lines, x[prefix:len(x)-suffix], y[prefix:len(y)-suffix])
+	lines = appendAll(lines, Equal, x[len(x)-suffix:])
+	return lines
+}
+
+// Count returns how many lines were deleted and inserted.
+func Count(lines []Line) (deleted, inserted int) {
+	for _, l := range lines {
+		switch l.Op {
+		case Delete:
+			deleted++
+		case Insert:
+			inserted++
+		}
+	}
+	return deleted, inserted
+}
+
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+func appendAll(lines []Line, op Op, texts []string) []Line {
+	for _, t := range texts {
+		lines = append(lines, Line{Op: op, Text: t})
+	}
+	return lines
+}
+
+func appendChanged(lines []Line, x, y []string) []Line {
+	n, m := len(x), len(y)
+	if n == 0 || m == 0 || n*m > maxCells {
+		lines = appendAll(lines, Delete, x)
+		return appendAll(lines, Insert, y)
+	}
+
+	// common[i*(m+1)+j] is the length of the longest common subsequence of
+	// x[i:] and y[j:].
+	w := m + 1
+	common := make([]int32, (n+1)*w)
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				common[i*w+j] = common[(i+1)*w+j+1] + 1
+			} else {
+				common[i*w+j] = max(common[(i+1)*w+j], common[i*w+j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case x[i] == y[j]:
+			lines = append(lines, Line{Op: Equal, Text: x[i]})
+			i++
+			j++
+		case common[(i+1)*w+j] >= common[i*w+j+1]:
+			lines = append(lines, Line{Op: Delete, Text: x[i]})
+			i++
+		default:
+			lines = append(lines, Line{Op: Insert, Text: y[j]})
+			j++
+		}
+	}
+	lines = appendAll(lines, Delete, x[i:])
+	return appendAll(lines, Insert, y[j:])
+}
@@ -0,0 +1,135 @@
+package diff
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func eq(text string) Line  { return Line{Op: Equal, Text: text} }
+func del(text string) Line { return Line{Op: Delete, Text: text} }
+func ins(text string) Line { return Line{Op: Insert, Text: text} }
+
+func TestLines(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		a, b string
+		want []Line
+	}{
+		{
+			name: "both empty",
+			a:    "",
+			b:    "",
+			want: []Line{},
+		},
+		{
+			name: "from empty",
+			a:    "",
+			b:    "a\nb",
+			want: []Line{ins("a"), ins("b")},
+		},
+		{
+			name: "to empty",
+			a:    "a\nb",
+			b:    "",
+			want: []Line{del("a"), del("b")},
+		},
+		{
+			name: "unchanged",
+			a:    "a\nb\nc",
+			b:    "a\nb\nc",
+			want: []Line{eq("a"), eq("b"), eq("c")},
+		},
+		{
+			name: "appended",
+			a:    "a\nb",
+			b:    "a\nb\nc",
+			want: []Line{eq("a"), eq("b"), ins("c")},
+		},
+		{
+			name: "prepended",
+			a:    "b\nc",
+			b:    "a\nb\nc",
+			want: []Line{ins("a"), eq("b"), eq("c")},
+		},
+		{
+			name: "changed between prefix and suffix",
+			a:    "a\nb\nc\nd",
+			b:    "a\nx\ny\nd",
+			want: []Line{eq("a"), del("b"), del("c"), ins("x"), ins("y"), eq("d")},
+		},
+		{
+			// The suffix must not overlap the prefix when a line repeats.
+			name: "repeated line inserted",
+			a:    "a\na",
+			b:    "a\na\na",
+			want: []Line{eq("a"), eq("a"), ins("a")},
+		},
+		{
+			name: "common lines kept in the middle",
+			a:    "start\na\nb\nc\nd\nend",
+			b:    "start\nb\nx\nd\ny\nend",
+			want: []Line{eq("start"), del("a"), eq("b"), del("c"), ins("x"), eq("d"), ins("y"), eq("end")},
+		},
+		{
+			name: "moved line",
+			a:    "a\nb\nc",
+			b:    "b\nc\na",
+			want: []Line{del("a"), eq("b"), eq("c"), ins("a")},
+		},
+		{
+			name: "trailing newline",
+			a:    "a",
+			b:    "a\n",
+			want: []Line{eq("a"), ins("")},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Lines(tt.a, tt.b)
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("Lines(%q, %q) =\n%v\nwant\n%v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+// numbered returns n lines named after the prefix.
+func numbered(prefix string, n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("%s%d", prefix, i)
+	}
+	return lines
+}
+
+func TestLinesTooLargeToCompare(t *testing.T) {
+	// Both sides share a line in the middle, but the changed part needs more
+	// than maxCells, so it is replaced as a whole.
+	x := append(numbered("old", 1000), "shared")
+	x = append(x, numbered("old-tail", 1000)...)
+	y := append(numbered("new", 1000), "shared")
+	y = append(y, numbered("new-tail", 1000)...)
+	if len(x)*len(y) <= maxCells {
+		t.Fatalf("test texts need %d cells, want more than %d", len(x)*len(y), maxCells)
+	}
+
+	got := Lines("first\n"+strings.Join(x, "\n")+"\nlast", "first\n"+strings.Join(y, "\n")+"\nlast")
+
+	want := []Line{eq("first")}
+	want = appendAll(want, Delete, x)
+	want = appendAll(want, Insert, y)
+	want = append(want, eq("last"))
+	if !slices.Equal(got, want) {
+		t.Fatalf("Lines kept %d lines, want the changed part replaced whole", len(got))
+	}
+}
+
+func TestCount(t *testing.T) {
+	lines := Lines("a\nb\nc\nd", "a\nx\nc\ny\nz")
+
+	deleted, inserted := Count(lines)
+	if deleted != 2 || inserted != 3 {
+		t.Fatalf("Count = %d deleted, %d inserted, want 2 and 3", deleted, inserted)
+	}
+}
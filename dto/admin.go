@@ -0,0 +1,26 @@
+package dto
+
+type ImpersonateRequest struct {
+	Username string `json:"username" validate:"required"`
+	Reason   string `json:"reason" validate:"required,max=500"`
+}
+
+type SetRoleRequest struct {
+	Username    string   `json:"username" validate:"required"`
+	Role        string   `json:"role" validate:"required"`
+	Permissions []string `json:"permissions" validate:"dive,required"`
+}
+
+// SuspendRequest suspends the user for DurationHours, or permanently if it
+// is zero.
+type SuspendRequest struct {
+	Username      string `json:"username" validate:"required"`
+	Reason        string `json:"reason" validate:"required,max=1000"`
+	DurationHours int    `json:"duration_hours" validate:"min=0,max=87600"`
+	HidePosts     bool   `json:"hide_posts"`
+}
+
+type UnsuspendRequest struct {
+	Username string `json:"username" validate:"required"`
+	Note     string `json:"note" validate:"max=1000"`
+}
@@ -1,13 +1,115 @@
 // Package dto provides data types for object transfer for requests.
 package dto
 
+import "encoding/json"
+
 type RegisterRequest struct {
 	Username string `json:"username" validate:"required,min=3,max=20"`
 	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=6,max=42"`
+	Password string `json:"password" validate:"required"`
+
+	// InviteCode is required unless registration is open to the address.
+	InviteCode string `json:"invite_code" validate:"max=64"`
 }
 
 type LoginRequest struct {
-	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=6,max=42"`
+	Email     string `json:"email" validate:"required,email"`
+	Password  string `json:"password" validate:"required,max=128"`
+	Device    string `json:"device" validate:"max=64"`
+	TokenMode string `json:"token_mode" validate:"omitempty,oneof=cookie body"`
+}
+
+// LoginMFARequest finishes a login started with LoginRequest. Exactly one of
+// Code and RecoveryCode is expected.
+type LoginMFARequest struct {
+	MFAToken     string `json:"mfa_token" validate:"required"`
+	Code         string `json:"code" validate:"required_without=RecoveryCode,omitempty,len=6,numeric"`
+	RecoveryCode string `json:"recovery_code" validate:"required_without=Code,omitempty,max=32"`
+	Device       string `json:"device" validate:"max=64"`
+	TokenMode    string `json:"token_mode" validate:"omitempty,oneof=cookie body"`
+}
+
+type TOTPCodeRequest struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+// ReauthenticateRequest confirms the identity of a signed-in user. Code is
+// required when two-factor authentication is enabled.
+type ReauthenticateRequest struct {
+	Password string `json:"password" validate:"required,max=128"`
+	Code     string `json:"code" validate:"omitempty,len=6,numeric"`
+}
+
+// OIDCReauthenticateRequest confirms the identity of a signed-in user through
+// one of their linked providers instead of a password.
+type OIDCReauthenticateRequest struct {
+	Provider string `json:"provider" validate:"required"`
+}
+
+type MagicLinkRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// PasskeyLoginRequest answers the options of /auth/passkey/begin. Credential
+// is the PublicKeyCredential returned by navigator.credentials.get.
+type PasskeyLoginRequest struct {
+	CeremonyID string          `json:"ceremony_id" validate:"required"`
+	Credential json.RawMessage `json:"credential" validate:"required"`
+	Device     string          `json:"device" validate:"max=64"`
+	TokenMode  string          `json:"token_mode" validate:"omitempty,oneof=cookie body"`
+}
+
+type PasskeyMFABeginRequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+}
+
+// PasskeyMFARequest finishes a login started with LoginRequest using a
+// passkey as the second factor.
+type PasskeyMFARequest struct {
+	MFAToken   string          `json:"mfa_token" validate:"required"`
+	CeremonyID string          `json:"ceremony_id" validate:"required"`
+	Credential json.RawMessage `json:"credential" validate:"required"`
+	Device     string          `json:"device" validate:"max=64"`
+	TokenMode  string          `json:"token_mode" validate:"omitempty,oneof=cookie body"`
+}
+
+type PasskeyAssertionRequest struct {
+	CeremonyID string          `json:"ceremony_id" validate:"required"`
+	Credential json.RawMessage `json:"credential" validate:"required"`
+}
+
+// AppealRequest appeals a suspension with the appeal token handed out when a
+// suspended user signs in.
+type AppealRequest struct {
+	AppealToken string `json:"appeal_token" validate:"required"`
+	Appeal      string `json:"appeal" validate:"required,max=2000"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+// RefreshRequest is optional for cookie clients, which send the refresh token
+// in the refresh_token cookie instead.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+	TokenMode    string `json:"token_mode" validate:"omitempty,oneof=cookie body"`
+}
+
+// CreateInviteRequest mints an invite for MaxUses registrations (one if zero)
+// valid for ExpiresInDays (the default if zero).
+type CreateInviteRequest struct {
+	MaxUses       int `json:"max_uses" validate:"min=0,max=1000"`
+	ExpiresInDays int `json:"expires_in_days" validate:"min=0,max=365"`
+}
+
+type CreateTokenRequest struct {
+	Name          string   `json:"name" validate:"required,min=1,max=64"`
+	Scopes        []string `json:"scopes" validate:"required,min=1,dive,required"`
+	ExpiresInDays int      `json:"expires_in_days" validate:"min=0,max=365"`
 }
@@ -1,7 +1,26 @@
 package dto
 
+import "time"
+
 type CreatePostRequest struct {
 	Title       string `json:"title" validate:"required,min=1,max=128"`
 	Description string `json:"description" validate:"required,min=1,max=256"`
 	Content     string `json:"content" validate:"required,min=1"`
+
+	// Status defaults to published. Scheduled posts go public at PublishAt.
+	Status    string     `json:"status" validate:"omitempty,oneof=draft scheduled published"`
+	PublishAt *time.Time `json:"publish_at"`
+}
+
+// UpdatePostRequest replaces the title, description and content of a post.
+type UpdatePostRequest struct {
+	Title       string `json:"title" validate:"required,min=1,max=128"`
+	Description string `json:"description" validate:"required,min=1,max=256"`
+	Content     string `json:"content" validate:"required,min=1"`
+}
+
+// SetPostStatusRequest moves a post to another state.
+type SetPostStatusRequest struct {
+	Status    string     `json:"status" validate:"required,oneof=draft scheduled published archived"`
+	PublishAt *time.Time `json:"publish_at"`
 }
@@ -0,0 +1,45 @@
+package dto
+
+import "encoding/json"
+
+// Sensitive changes carry either the current password or rely on a sudo
+// token from /auth/reauthenticate.
+
+type UpdateUsernameRequest struct {
+	Username        string `json:"username" validate:"required,min=3,max=20"`
+	CurrentPassword string `json:"current_password"`
+}
+
+type UpdateDisplayNameRequest struct {
+	DisplayName string `json:"display_name" validate:"required,min=1,max=64"`
+}
+
+type UpdateEmailRequest struct {
+	Email           string `json:"email" validate:"required,email"`
+	CurrentPassword string `json:"current_password"`
+}
+
+type UpdatePasswordRequest struct {
+	Password        string `json:"password" validate:"required"`
+	CurrentPassword string `json:"current_password"`
+}
+
+// LinkIdentityRequest starts linking an account at an OpenID Connect
+// provider to the signed-in user.
+type LinkIdentityRequest struct {
+	Provider        string `json:"provider" validate:"required"`
+	CurrentPassword string `json:"current_password"`
+}
+
+type BeginPasskeyRequest struct {
+	CurrentPassword string `json:"current_password"`
+}
+
+// FinishPasskeyRequest answers the options of /settings/passkeys/begin.
+// Credential is the PublicKeyCredential returned by
+// navigator.credentials.create.
+type FinishPasskeyRequest struct {
+	CeremonyID string          `json:"ceremony_id" validate:"required"`
+	Name       string          `json:"name" validate:"required,min=1,max=64"`
+	Credential json.RawMessage `json:"credential" validate:"required"`
+}
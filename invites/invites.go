@@ -0,0 +1,188 @@
+// Package invites decides who may register and manages the invite codes that
+// let people in while registration is not open to everyone.
+package invites
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/kostya-zero/blogger/helpers"
+	"github.com/kostya-zero/blogger/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Registration modes. In ModeDomains addresses of the allowed domains may
+// register freely and everyone else needs an invite.
+const (
+	ModeOpen    = "open"
+	ModeInvite  = "invite"
+	ModeDomains = "domains"
+	ModeClosed  = "closed"
+)
+
+var Modes = []string{ModeOpen, ModeInvite, ModeDomains, ModeClosed}
+
+// Error is a reason to refuse a registration. Code is stable for clients to
+// act on; Message is meant for people.
+type Error struct {
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+var (
+	ErrClosed           = &Error{"registration_closed", "Registration is closed"}
+	ErrInviteRequired   = &Error{"invite_required", "An invite code is required to register"}
+	ErrDomainNotAllowed = &Error{"domain_not_allowed", "Registration is limited to certain email domains, an invite code is required for others"}
+	ErrInviteInvalid    = &Error{"invite_invalid", "Invite code is not valid"}
+	ErrInviteExpired    = &Error{"invite_expired", "Invite code has expired"}
+	ErrInviteUsedUp     = &Error{"invite_used_up", "Invite code has been used up"}
+)
+
+var ErrUnknownMode = errors.New("unknown registration mode")
+
+type Store struct {
+	DB      *gorm.DB
+	Mode    string
+	Domains []string
+}
+
+// NewStore returns a store enforcing the mode. Domains are only used in
+// ModeDomains.
+func NewStore(db *gorm.DB, mode string, domains []string) (*Store, error) {
+	if !slices.Contains(Modes, mode) {
+		return nil, fmt.Errorf("%w %q", ErrUnknownMode, mode)
+	}
+
+	normalized := make([]string, 0, len(domains))
+	for _, d := range domains {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			normalized = append(normalized, d)
+		}
+	}
+
+	return &Store{DB: db, Mode: mode, Domains: normalized}, nil
+}
+
+// Check reports whether email may register, without looking at any invite
+// code. invited says whether the user brought one.
+func (s *Store) Check(email string, invited bool) error {
+	switch s.Mode {
+	case ModeOpen:
+		return nil
+	case ModeClosed:
+		return ErrClosed
+	case ModeDomains:
+		if invited || s.domainAllowed(email) {
+			return nil
+		}
+		return ErrDomainNotAllowed
+	}
+
+	if !invited {
+		return ErrInviteRequired
+	}
+	return nil
+}
+
+func (s *Store) domainAllowed(email string) bool {
+	at := strings.LastIndexByte(email, '@')
+	return at >= 0 && slices.Contains(s.Domains, strings.ToLower(email[at+1:]))
+}
+
+// Admit checks that email may register and redeems the invite code, if any,
+// within tx so that the use is undone if the registration fails. The invite
+// is returned when one was redeemed.
+func (s *Store) Admit(tx *gorm.DB, email, code string) (*models.Invite, error) {
+	if err := s.Check(email, code != ""); err != nil {
+		return nil, err
+	}
+	if code == "" {
+		return nil, nil
+	}
+
+	var invite models.Invite
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("code_hash = ? AND revoked_at IS NULL", helpers.HashToken(code)).
+		First(&invite).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInviteInvalid
+		}
+		return nil, err
+	}
+
+	if invite.ExpiresAt != nil && time.Now().After(*invite.ExpiresAt) {
+		return nil, ErrInviteExpired
+	}
+	if invite.Uses >= invite.MaxUses {
+		return nil, ErrInviteUsedUp
+	}
+
+	if err := tx.Model(&invite).Update("uses", gorm.Expr("uses + 1")).Error; err != nil {
+		return nil, err
+	}
+	invite.Uses++
+
+	return &invite, nil
+}
+
+// Create mints an invite for maxUses registrations. The code is returned only
+// here; just its hash is stored.
+func (s *Store) Create(inviterID *uint, maxUses int, expiresAt *time.Time) (string, *models.Invite, error) {
+	code, err := helpers.RandomToken(12)
+	if err != nil {
+		return "", nil, err
+	}
+
+	invite := models.Invite{
+		InviterID: inviterID,
+		Prefix:    code[:6],
+		CodeHash:  helpers.HashToken(code),
+		MaxUses:   maxUses,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+	if err := s.DB.Create(&invite).Error; err != nil {
+		return "", nil, err
+	}
+
+	return code, &invite, nil
+}
+
+// List returns the invites of the inviter that are not revoked.
+func (s *Store) List(inviterID uint) ([]models.Invite, error) {
+	var list []models.Invite
+	err := s.DB.Where("inviter_id = ? AND revoked_at IS NULL", inviterID).Order("created_at DESC").Find(&list).Error
+	return list, err
+}
+
+// Outstanding counts the invites of the inviter that can still be used.
+func (s *Store) Outstanding(inviterID uint) (int64, error) {
+	var count int64
+	err := s.DB.Model(&models.Invite{}).
+		Where("inviter_id = ? AND revoked_at IS NULL AND uses < max_uses", inviterID).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Count(&count).Error
+	return count, err
+}
+
+func (s *Store) Revoke(inviterID, inviteID uint) error {
+	result := s.DB.Model(&models.Invite{}).
+		Where("id = ? AND inviter_id = ? AND revoked_at IS NULL", inviteID, inviterID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
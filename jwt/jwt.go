@@ -5,29 +5,107 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+const issuer = "blogger"
+
+// ImpersonationHeader is set on every response to a request made with an
+// impersonation token, to the ID of the staff member behind it.
+const ImpersonationHeader = "X-Impersonated-By"
+
+// Actor is who really acts with a token issued to act as another user
+// (RFC 8693).
+type Actor struct {
+	UserID uint `json:"sub"`
+}
+
 type TokenClaims struct {
-	UserID uint   `json:"sub"`
-	Issuer string `json:"iss"`
+	UserID    uint   `json:"sub"`
+	SessionID uint   `json:"sid"`
+	Issuer    string `json:"iss"`
 	jwt.RegisteredClaims
+
+	// AMR lists the authentication methods used when the session started and
+	// AuthTime is when that happened.
+	AMR      []string         `json:"amr,omitempty"`
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+
+	// Role and Permissions are those of the user when the token was issued.
+	Role        string   `json:"role,omitempty"`
+	Permissions []string `json:"perms,omitempty"`
+
+	// Act is set on tokens staff use to act as UserID. SessionID is then the
+	// staff member's session.
+	Act *Actor `json:"act,omitempty"`
+
+	// Use is empty for access tokens and names the purpose of short-lived
+	// tokens that must not be accepted as access tokens, such as "mfa".
+	Use string `json:"use,omitempty"`
+
+	// PersonalToken marks claims resolved from a personal access token rather
+	// than a signed JWT. Such claims are limited to Scopes and carry no
+	// permissions.
+	PersonalToken bool     `json:"-"`
+	Scopes        []string `json:"-"`
+
+	// Legacy marks claims of an HS256 token issued before sessions existed.
+	// They carry no session and are accepted only until the key ring's legacy
+	// cutoff.
+	Legacy bool `json:"-"`
+}
+
+// SetAuth records how and when the session was authenticated.
+func (c *TokenClaims) SetAuth(amr []string, at time.Time) {
+	c.AMR = amr
+	c.AuthTime = jwt.NewNumericDate(at)
+}
+
+// HasMethod reports whether the session was authenticated with the method.
+func (c *TokenClaims) HasMethod(method string) bool {
+	return slices.Contains(c.AMR, method)
 }
 
+// HasScope reports whether the claims allow the scope. Session tokens allow
+// everything.
+func (c *TokenClaims) HasScope(scope string) bool {
+	return !c.PersonalToken || slices.Contains(c.Scopes, scope)
+}
+
+// HasPermission reports whether the user had the permission when the token
+// was issued.
+func (c *TokenClaims) HasPermission(permission string) bool {
+	return slices.Contains(c.Permissions, permission)
+}
+
+// Impersonated reports whether someone else acts as the user with the token.
+func (c *TokenClaims) Impersonated() bool {
+	return c.Act != nil
+}
+
+// Check is an additional verification JwtMiddleware runs on the claims of an
+// otherwise valid token.
+type Check func(claims *TokenClaims) error
+
 func generateJTI() string {
 	b := make([]byte, 16)
 	rand.Read(b)
 	return base64.URLEncoding.EncodeToString(b)
 }
 
-func CreateToken(userID uint, secret string, ttl time.Duration) (string, error) {
+// NewClaims builds claims for an access token of the user's session.
+func NewClaims(userID, sessionID uint, ttl time.Duration) *TokenClaims {
 	now := time.Now()
-	claims := TokenClaims{
-		UserID: userID,
-		Issuer: "blogger",
+	return &TokenClaims{
+		UserID:    userID,
+		SessionID: sessionID,
+		Issuer:    issuer,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
 			IssuedAt:  jwt.NewNumericDate(now),
@@ -35,9 +113,18 @@ func CreateToken(userID uint, secret string, ttl time.Duration) (string, error)
 			ID:        generateJTI(),
 		},
 	}
+}
+
+// SignToken signs the claims with the current key of the key ring.
+func SignToken(claims *TokenClaims, keys *KeyRing) (string, error) {
+	key := keys.current()
+	if key == nil {
+		return "", errors.New("no signing key available")
+	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(secret))
+	token := jwt.NewWithClaims(signingMethod(key.Algorithm), claims)
+	token.Header["kid"] = key.ID
+	tokenString, err := token.SignedString(key.Private)
 	if err != nil {
 		return "", errors.New("could not sign token: " + err.Error())
 	}
@@ -45,13 +132,9 @@ func CreateToken(userID uint, secret string, ttl time.Duration) (string, error)
 	return tokenString, nil
 }
 
-func ParseToken(tokenStr, secret string) (*TokenClaims, error) {
-	token, err := jwt.ParseWithClaims(tokenStr, &TokenClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("unexpected signing method")
-		}
-		return []byte(secret), nil
-	})
+// ParseToken verifies the token with the key its kid header points to.
+func ParseToken(tokenStr string, keys *KeyRing) (*TokenClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenStr, &TokenClaims{}, keys.keyFunc)
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
 			return nil, errors.New("token expired")
@@ -71,30 +154,158 @@ func ParseToken(tokenStr, secret string) (*TokenClaims, error) {
 		return nil, errors.New("could not parse claims")
 	}
 
-	if claims.Issuer != "Blogger" {
+	if claims.Issuer != issuer {
 		return nil, errors.New("invalid token issuer")
 	}
 
+	_, claims.Legacy = token.Method.(*jwt.SigningMethodHMAC)
 	return claims, nil
 }
 
-func JwtMiddleware(secret string) fiber.Handler {
+// TokenFromRequest returns the access token from the Authorization header,
+// falling back to the access_token cookie.
+func TokenFromRequest(c *fiber.Ctx) string {
+	header := c.Get(fiber.HeaderAuthorization)
+	if len(header) > 7 && strings.EqualFold(header[:7], "Bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+
+	return c.Cookies("access_token")
+}
+
+func JwtMiddleware(keys *KeyRing, checks ...Check) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		token := c.Cookies("access_token")
+		token := TokenFromRequest(c)
 		if token == "" {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error": "Access denied.",
 			})
 		}
 
-		claims, err := ParseToken(token, secret)
-		if err != nil {
+		claims, err := ParseToken(token, keys)
+		if err != nil || claims.Use != "" {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error": "Invalid or expired access token.",
 			})
 		}
 
+		for _, check := range checks {
+			if err := check(claims); err != nil {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"error": "Invalid or expired access token.",
+				})
+			}
+		}
+
 		c.Locals("user", claims)
+		if claims.Impersonated() {
+			c.Set(ImpersonationHeader, strconv.FormatUint(uint64(claims.Act.UserID), 10))
+		}
+
+		return c.Next()
+	}
+}
+
+// Optional runs auth only for requests that carry a token, for routes that
+// serve everyone but show signed in users more.
+func Optional(auth fiber.Handler) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if TokenFromRequest(c) == "" {
+			return c.Next()
+		}
+		return auth(c)
+	}
+}
+
+// RequireScope rejects personal access tokens that were not granted the scope.
+func RequireScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, ok := c.Locals("user").(*TokenClaims)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Access denied."})
+		}
+
+		if !claims.HasScope(scope) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Token is missing the '" + scope + "' scope.",
+			})
+		}
+
+		return c.Next()
+	}
+}
+
+// RequirePermission rejects requests of users without the permission.
+func RequirePermission(permission string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, ok := c.Locals("user").(*TokenClaims)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Access denied."})
+		}
+
+		if !claims.HasPermission(permission) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Missing the '" + permission + "' permission.",
+			})
+		}
+
+		return c.Next()
+	}
+}
+
+// RequireSession rejects personal access tokens, for routes that manage the
+// account's credentials themselves.
+func RequireSession() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, ok := c.Locals("user").(*TokenClaims)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Access denied."})
+		}
+
+		if claims.PersonalToken {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "This route is not available to personal access tokens.",
+			})
+		}
+
+		return c.Next()
+	}
+}
+
+// RejectImpersonation keeps staff acting as a user away from routes that
+// change the user's account or credentials.
+func RejectImpersonation() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, ok := c.Locals("user").(*TokenClaims)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Access denied."})
+		}
+
+		if claims.Impersonated() {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "This route is not available while impersonating a user.",
+			})
+		}
+
+		return c.Next()
+	}
+}
+
+// RequireRecentAuth rejects sessions that were not authenticated with the
+// method within maxAge, e.g. to demand a recent second factor.
+func RequireRecentAuth(method string, maxAge time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, ok := c.Locals("user").(*TokenClaims)
+		if !ok {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Access denied."})
+		}
+
+		if !claims.HasMethod(method) || claims.AuthTime == nil || time.Since(claims.AuthTime.Time) > maxAge {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error":  "Sign in again to continue.",
+				"reauth": method,
+			})
+		}
 
 		return c.Next()
 	}
@@ -0,0 +1,257 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// Key is a signing key identified by the kid header of the tokens it signs.
+type Key struct {
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+	CreatedAt time.Time
+	RetiredAt *time.Time
+}
+
+// KeyStore persists signing keys so that every instance signs and verifies
+// with the same set.
+type KeyStore interface {
+	LoadKeys() ([]*Key, error)
+	SaveKey(key *Key) error
+	RetireKey(id string, at time.Time) error
+	DeleteKey(id string) error
+}
+
+// KeyRing holds the active signing key and the retired keys still accepted
+// for verification.
+type KeyRing struct {
+	mu        sync.RWMutex
+	keys      []*Key
+	algorithm string
+	store     KeyStore
+
+	// legacySecret, when set, lets HS256 tokens signed before the switch to
+	// asymmetric keys pass verification until legacyUntil.
+	legacySecret []byte
+	legacyUntil  time.Time
+}
+
+// NewKeyRing loads keys from the store and creates the first one if there are
+// none yet. HS256 tokens signed with legacySecret are accepted only while
+// legacyUntil is in the future and only if they were issued before it.
+func NewKeyRing(store KeyStore, algorithm, legacySecret string, legacyUntil time.Time) (*KeyRing, error) {
+	if algorithm != AlgorithmRS256 && algorithm != AlgorithmEdDSA {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+
+	kr := &KeyRing{algorithm: algorithm, store: store}
+	if legacySecret != "" && time.Now().Before(legacyUntil) {
+		kr.legacySecret = []byte(legacySecret)
+		kr.legacyUntil = legacyUntil
+	}
+
+	if err := kr.reload(); err != nil {
+		return nil, err
+	}
+
+	if kr.current() == nil {
+		if err := kr.rotate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return kr, nil
+}
+
+// StartRotation replaces the signing key once it is older than interval.
+// Retired keys are deleted after overlap, which must be longer than the
+// lifetime of the tokens they signed.
+func (kr *KeyRing) StartRotation(interval, overlap time.Duration) {
+	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := kr.maintain(interval, overlap); err != nil {
+				fmt.Printf("Failed to rotate signing keys: %s\n", err.Error())
+			}
+		}
+	}()
+}
+
+func (kr *KeyRing) maintain(interval, overlap time.Duration) error {
+	// Other instances may have rotated in the meantime.
+	if err := kr.reload(); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if current := kr.current(); current == nil || now.Sub(current.CreatedAt) >= interval {
+		if err := kr.rotate(); err != nil {
+			return err
+		}
+	}
+
+	kr.mu.RLock()
+	var expired []string
+	for _, key := range kr.keys {
+		if key.RetiredAt != nil && now.Sub(*key.RetiredAt) >= overlap {
+			expired = append(expired, key.ID)
+		}
+	}
+	kr.mu.RUnlock()
+
+	for _, id := range expired {
+		if err := kr.store.DeleteKey(id); err != nil {
+			return err
+		}
+	}
+
+	if len(expired) > 0 {
+		return kr.reload()
+	}
+
+	return nil
+}
+
+func (kr *KeyRing) reload() error {
+	keys, err := kr.store.LoadKeys()
+	if err != nil {
+		return err
+	}
+
+	kr.mu.Lock()
+	kr.keys = keys
+	kr.mu.Unlock()
+	return nil
+}
+
+// rotate generates a new signing key and retires every key that was signing
+// before it.
+func (kr *KeyRing) rotate() error {
+	key, err := generateKey(kr.algorithm)
+	if err != nil {
+		return err
+	}
+
+	kr.mu.RLock()
+	var active []string
+	for _, k := range kr.keys {
+		if k.RetiredAt == nil {
+			active = append(active, k.ID)
+		}
+	}
+	kr.mu.RUnlock()
+
+	if err := kr.store.SaveKey(key); err != nil {
+		return err
+	}
+
+	for _, id := range active {
+		if err := kr.store.RetireKey(id, key.CreatedAt); err != nil {
+			return err
+		}
+	}
+
+	return kr.reload()
+}
+
+// current returns the newest key that is not retired.
+func (kr *KeyRing) current() *Key {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	var current *Key
+	for _, key := range kr.keys {
+		if key.RetiredAt == nil && (current == nil || key.CreatedAt.After(current.CreatedAt)) {
+			current = key
+		}
+	}
+	return current
+}
+
+func (kr *KeyRing) find(id string) *Key {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+
+	for _, key := range kr.keys {
+		if key.ID == id {
+			return key
+		}
+	}
+	return nil
+}
+
+// keyFunc selects the verification key for a token by its kid header.
+func (kr *KeyRing) keyFunc(token *jwt.Token) (interface{}, error) {
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+		if kr.legacySecret == nil || token.Method.Alg() != jwt.SigningMethodHS256.Alg() {
+			return nil, errors.New("unexpected signing method")
+		}
+
+		claims, ok := token.Claims.(*TokenClaims)
+		if !ok || claims.IssuedAt == nil || !claims.IssuedAt.Before(kr.legacyUntil) || !time.Now().Before(kr.legacyUntil) {
+			return nil, errors.New("legacy token no longer accepted")
+		}
+		return kr.legacySecret, nil
+	}
+
+	kid, _ := token.Header["kid"].(string)
+	key := kr.find(kid)
+	if key == nil {
+		return nil, errors.New("unknown signing key")
+	}
+
+	if token.Method.Alg() != key.Algorithm {
+		return nil, errors.New("unexpected signing method")
+	}
+
+	return key.Private.Public(), nil
+}
+
+func signingMethod(algorithm string) jwt.SigningMethod {
+	if algorithm == AlgorithmRS256 {
+		return jwt.SigningMethodRS256
+	}
+	return jwt.SigningMethodEdDSA
+}
+
+func generateKey(algorithm string) (*Key, error) {
+	var (
+		private crypto.Signer
+		err     error
+	)
+
+	switch algorithm {
+	case AlgorithmRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case AlgorithmEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not generate signing key: %w", err)
+	}
+
+	return &Key{
+		ID:        generateJTI(),
+		Algorithm: algorithm,
+		Private:   private,
+		CreatedAt: time.Now(),
+	}, nil
+}
@@ -0,0 +1,78 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"time"
+
+	"github.com/kostya-zero/blogger/models"
+	"gorm.io/gorm"
+)
+
+// DBKeyStore keeps signing keys in the signing_keys table as PKCS #8 PEM.
+type DBKeyStore struct {
+	DB *gorm.DB
+}
+
+func NewDBKeyStore(db *gorm.DB) *DBKeyStore {
+	return &DBKeyStore{DB: db}
+}
+
+func (s *DBKeyStore) LoadKeys() ([]*Key, error) {
+	var rows []models.SigningKey
+	if err := s.DB.Order("created_at").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	keys := make([]*Key, 0, len(rows))
+	for _, row := range rows {
+		block, _ := pem.Decode([]byte(row.PrivateKey))
+		if block == nil {
+			return nil, errors.New("signing key " + row.ID + " is not valid PEM")
+		}
+
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		private, ok := parsed.(crypto.Signer)
+		if !ok {
+			return nil, errors.New("signing key " + row.ID + " has unsupported type")
+		}
+
+		keys = append(keys, &Key{
+			ID:        row.ID,
+			Algorithm: row.Algorithm,
+			Private:   private,
+			CreatedAt: row.CreatedAt,
+			RetiredAt: row.RetiredAt,
+		})
+	}
+
+	return keys, nil
+}
+
+func (s *DBKeyStore) SaveKey(key *Key) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.Private)
+	if err != nil {
+		return err
+	}
+
+	return s.DB.Create(&models.SigningKey{
+		ID:         key.ID,
+		Algorithm:  key.Algorithm,
+		PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		CreatedAt:  key.CreatedAt,
+	}).Error
+}
+
+func (s *DBKeyStore) RetireKey(id string, at time.Time) error {
+	return s.DB.Model(&models.SigningKey{}).Where("id = ? AND retired_at IS NULL", id).Update("retired_at", at).Error
+}
+
+func (s *DBKeyStore) DeleteKey(id string) error {
+	return s.DB.Where("id = ?", id).Delete(&models.SigningKey{}).Error
+}
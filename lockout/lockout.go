@@ -0,0 +1,96 @@
+// Package lockout slows down password guessing by locking keys (accounts, IP
+// addresses) for exponentially growing periods after repeated failures.
+package lockout
+
+import (
+	"time"
+)
+
+// Attempts is the failure state of a key.
+type Attempts struct {
+	Failures    int
+	LockedUntil time.Time
+}
+
+// Store keeps failure counters. Failures older than window are forgotten.
+type Store interface {
+	Get(key string) (Attempts, error)
+	// Fail increments the counter and returns the new state.
+	Fail(key string, window time.Duration) (Attempts, error)
+	Lock(key string, until time.Time) error
+	Reset(key string) error
+}
+
+// Limiter applies a lockout policy to the keys of one kind.
+type Limiter struct {
+	Store Store
+
+	// Threshold failures within Window lock the key for BaseDelay; every
+	// further failure doubles the delay up to MaxDelay.
+	Threshold int
+	Window    time.Duration
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// LockedFor returns how long the key stays locked, or zero.
+func (l *Limiter) LockedFor(key string) (time.Duration, error) {
+	attempts, err := l.Store.Get(key)
+	if err != nil {
+		return 0, err
+	}
+
+	if remaining := time.Until(attempts.LockedUntil); remaining > 0 {
+		return remaining, nil
+	}
+	return 0, nil
+}
+
+// Fail records a failure and reports whether it locked the key.
+func (l *Limiter) Fail(key string) (time.Duration, error) {
+	attempts, err := l.Store.Fail(key, l.Window)
+	if err != nil {
+		return 0, err
+	}
+
+	if attempts.Failures < l.Threshold {
+		return 0, nil
+	}
+
+	delay := l.BaseDelay
+	for i := l.Threshold; i < attempts.Failures && delay < l.MaxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, l.MaxDelay)
+
+	if err := l.Store.Lock(key, time.Now().Add(delay)); err != nil {
+		return 0, err
+	}
+
+	return delay, nil
+}
+
+func (l *Limiter) Succeed(key string) error {
+	return l.Store.Reset(key)
+}
+
+// Guard combines the per-account and per-IP limiters used by login.
+type Guard struct {
+	Accounts *Limiter
+	IPs      *Limiter
+}
+
+func NewGuard(store Store) *Guard {
+	return &Guard{
+		Accounts: &Limiter{Store: store, Threshold: 5, Window: time.Hour, BaseDelay: 30 * time.Second, MaxDelay: time.Hour},
+		IPs:      &Limiter{Store: store, Threshold: 20, Window: time.Hour, BaseDelay: time.Minute, MaxDelay: time.Hour},
+	}
+}
+
+func AccountKey(email string) string {
+	return "account:" + email
+}
+
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
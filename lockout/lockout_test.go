@@ -0,0 +1,87 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+func newTestLimiter() *Limiter {
+	return &Limiter{
+		Store:     NewMemoryStore(time.Hour, time.Hour),
+		Threshold: 3,
+		Window:    time.Hour,
+		BaseDelay: time.Minute,
+		MaxDelay:  4 * time.Minute,
+	}
+}
+
+func TestLimiterBackoff(t *testing.T) {
+	l := newTestLimiter()
+
+	// Below the threshold nothing is locked, then the delay doubles with
+	// every failure until it reaches MaxDelay.
+	for i, want := range []time.Duration{0, 0, time.Minute, 2 * time.Minute, 4 * time.Minute, 4 * time.Minute} {
+		delay, err := l.Fail("account:reader@example.com")
+		if err != nil {
+			t.Fatalf("fail %d: %v", i+1, err)
+		}
+		if delay != want {
+			t.Fatalf("failure %d locked for %s, want %s", i+1, delay, want)
+		}
+
+		locked, err := l.LockedFor("account:reader@example.com")
+		if err != nil {
+			t.Fatalf("locked for: %v", err)
+		}
+		if (locked > 0) != (want > 0) || locked > want {
+			t.Fatalf("after failure %d locked for %s, want up to %s", i+1, locked, want)
+		}
+	}
+}
+
+func TestLimiterSucceedResets(t *testing.T) {
+	l := newTestLimiter()
+	key := "account:reader@example.com"
+
+	for range l.Threshold - 1 {
+		l.Fail(key)
+	}
+	if err := l.Succeed(key); err != nil {
+		t.Fatalf("succeed: %v", err)
+	}
+
+	// The count starts over, so the next failure doesn't lock.
+	if delay, _ := l.Fail(key); delay != 0 {
+		t.Fatalf("failure after success locked for %s", delay)
+	}
+	if locked, _ := l.LockedFor(key); locked != 0 {
+		t.Fatalf("locked for %s after success", locked)
+	}
+}
+
+func TestGuardSeparatesAccountsAndIPs(t *testing.T) {
+	g := NewGuard(NewMemoryStore(time.Hour, time.Hour))
+	account, ip := AccountKey("reader@example.com"), IPKey("192.0.2.1")
+
+	for range g.Accounts.Threshold {
+		g.Accounts.Fail(account)
+	}
+
+	if locked, _ := g.Accounts.LockedFor(account); locked == 0 {
+		t.Fatal("account is not locked")
+	}
+	if locked, _ := g.IPs.LockedFor(ip); locked != 0 {
+		t.Fatalf("IP locked for %s by account failures", locked)
+	}
+	if locked, _ := g.Accounts.LockedFor(AccountKey("writer@example.com")); locked != 0 {
+		t.Fatalf("other account locked for %s", locked)
+	}
+
+	// The IP limiter has its own, higher threshold.
+	for range g.Accounts.Threshold {
+		g.IPs.Fail(ip)
+	}
+	if locked, _ := g.IPs.LockedFor(ip); locked != 0 {
+		t.Fatalf("IP locked for %s below its threshold", locked)
+	}
+}
@@ -0,0 +1,91 @@
+package lockout
+
+import (
+	"sync"
+	"time"
+)
+
+type memoryEntry struct {
+	Attempts
+	lastFailure time.Time
+}
+
+// MemoryStore keeps counters in process memory, for a single instance.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+}
+
+// NewMemoryStore creates a store that drops entries idle for longer than
+// maxAge every interval.
+func NewMemoryStore(interval, maxAge time.Duration) *MemoryStore {
+	s := &MemoryStore{entries: make(map[string]*memoryEntry)}
+	go s.evictLoop(interval, maxAge)
+	return s
+}
+
+func (s *MemoryStore) Get(key string) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok {
+		return entry.Attempts, nil
+	}
+	return Attempts{}, nil
+}
+
+func (s *MemoryStore) Fail(key string, window time.Duration) (Attempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	entry, ok := s.entries[key]
+	if !ok || now.Sub(entry.lastFailure) > window {
+		entry = &memoryEntry{Attempts: Attempts{LockedUntil: entryLock(entry)}}
+		s.entries[key] = entry
+	}
+
+	entry.Failures++
+	entry.lastFailure = now
+	return entry.Attempts, nil
+}
+
+func (s *MemoryStore) Lock(key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.entries[key]; ok {
+		entry.LockedUntil = until
+	}
+	return nil
+}
+
+func (s *MemoryStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *MemoryStore) evictLoop(interval, maxAge time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		s.mu.Lock()
+		for key, entry := range s.entries {
+			if now.Sub(entry.lastFailure) > maxAge && now.After(entry.LockedUntil) {
+				delete(s.entries, key)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+func entryLock(entry *memoryEntry) time.Time {
+	if entry == nil {
+		return time.Time{}
+	}
+	return entry.LockedUntil
+}
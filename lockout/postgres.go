@@ -0,0 +1,90 @@
+package lockout
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kostya-zero/blogger/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PostgresStore keeps counters in the login_attempts table, shared by every
+// instance.
+type PostgresStore struct {
+	DB *gorm.DB
+}
+
+// NewPostgresStore creates a store that deletes rows idle for longer than
+// maxAge every interval.
+func NewPostgresStore(db *gorm.DB, interval, maxAge time.Duration) *PostgresStore {
+	s := &PostgresStore{DB: db}
+	go s.cleanupLoop(interval, maxAge)
+	return s
+}
+
+func (s *PostgresStore) Get(key string) (Attempts, error) {
+	var row models.LoginAttempt
+	if err := s.DB.Where("key = ?", key).First(&row).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return Attempts{}, nil
+		}
+		return Attempts{}, err
+	}
+
+	return toAttempts(row), nil
+}
+
+func (s *PostgresStore) Fail(key string, window time.Duration) (Attempts, error) {
+	now := time.Now()
+	row := models.LoginAttempt{Key: key, Failures: 1, LastFailureAt: now}
+
+	// A single upsert keeps concurrent failures from being lost.
+	err := s.DB.Clauses(
+		clause.OnConflict{
+			Columns: []clause.Column{{Name: "key"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"failures":        gorm.Expr("CASE WHEN login_attempts.last_failure_at < ? THEN 1 ELSE login_attempts.failures + 1 END", now.Add(-window)),
+				"last_failure_at": now,
+			}),
+		},
+		clause.Returning{},
+	).Create(&row).Error
+	if err != nil {
+		return Attempts{}, err
+	}
+
+	return toAttempts(row), nil
+}
+
+func (s *PostgresStore) Lock(key string, until time.Time) error {
+	return s.DB.Model(&models.LoginAttempt{}).Where("key = ?", key).Update("locked_until", until).Error
+}
+
+func (s *PostgresStore) Reset(key string) error {
+	return s.DB.Where("key = ?", key).Delete(&models.LoginAttempt{}).Error
+}
+
+func (s *PostgresStore) cleanupLoop(interval, maxAge time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+		err := s.DB.
+			Where("last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)", now.Add(-maxAge), now).
+			Delete(&models.LoginAttempt{}).Error
+		if err != nil {
+			fmt.Printf("Failed to clean up login attempts: %s\n", err.Error())
+		}
+	}
+}
+
+func toAttempts(row models.LoginAttempt) Attempts {
+	attempts := Attempts{Failures: row.Failures}
+	if row.LockedUntil != nil {
+		attempts.LockedUntil = *row.LockedUntil
+	}
+	return attempts
+}
@@ -0,0 +1,38 @@
+package mailer
+
+import "fmt"
+
+// Queue runs email jobs on a fixed number of workers, so that anonymous
+// endpoints which send mail in the background cannot start an unbounded
+// number of goroutines. Jobs that do not fit in the queue are dropped.
+type Queue struct {
+	jobs chan func() error
+}
+
+// NewQueue starts workers that take jobs from a queue holding up to size.
+func NewQueue(workers, size int) *Queue {
+	q := &Queue{jobs: make(chan func() error, size)}
+	for range workers {
+		go q.work()
+	}
+	return q
+}
+
+// Submit queues the job and reports whether there was room for it. The job
+// runs after the request returns, so it must not hold on to request memory.
+func (q *Queue) Submit(job func() error) bool {
+	select {
+	case q.jobs <- job:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *Queue) work() {
+	for job := range q.jobs {
+		if err := job(); err != nil {
+			fmt.Printf("Failed to send email: %s\n", err.Error())
+		}
+	}
+}
@@ -2,12 +2,31 @@ package main
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/kostya-zero/blogger/helpers"
+	"github.com/kostya-zero/blogger/invites"
 	"github.com/kostya-zero/blogger/jwt"
+	"github.com/kostya-zero/blogger/lockout"
+	"github.com/kostya-zero/blogger/mailer"
 	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/moderation"
+	"github.com/kostya-zero/blogger/oidc"
+	"github.com/kostya-zero/blogger/onetime"
+	"github.com/kostya-zero/blogger/passkeys"
+	"github.com/kostya-zero/blogger/password"
+	"github.com/kostya-zero/blogger/pat"
+	"github.com/kostya-zero/blogger/publishing"
+	"github.com/kostya-zero/blogger/revocation"
+	"github.com/kostya-zero/blogger/roles"
 	"github.com/kostya-zero/blogger/routes"
+	"github.com/kostya-zero/blogger/sessions"
+	"github.com/kostya-zero/blogger/trash"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/logger"
@@ -15,6 +34,121 @@ import (
 	"gorm.io/gorm"
 )
 
+// getEnvDuration reads a duration such as "24h" from the environment,
+// falling back to def when it is unset or malformed.
+func getEnvDuration(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		fmt.Printf("Invalid duration in %s, using %s\n", name, def)
+		return def
+	}
+
+	return d
+}
+
+// getEnvInt reads an integer from the environment, falling back to def when it
+// is unset or malformed.
+func getEnvInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		fmt.Printf("Invalid number in %s, using %d\n", name, def)
+		return def
+	}
+
+	return n
+}
+
+// newMailer picks the mail transport from BLOGGER_MAILER: "smtp", "file"
+// (writes .eml files to BLOGGER_MAIL_OUTBOX) or "memory".
+func newMailer() mailer.Mailer {
+	from := os.Getenv("BLOGGER_MAIL_FROM")
+	switch os.Getenv("BLOGGER_MAILER") {
+	case "smtp":
+		return &mailer.SMTPMailer{
+			Host:     os.Getenv("BLOGGER_SMTP_HOST"),
+			Port:     os.Getenv("BLOGGER_SMTP_PORT"),
+			Username: os.Getenv("BLOGGER_SMTP_USERNAME"),
+			Password: os.Getenv("BLOGGER_SMTP_PASSWORD"),
+			From:     from,
+		}
+	case "memory":
+		return &mailer.MemoryOutbox{}
+	default:
+		dir := os.Getenv("BLOGGER_MAIL_OUTBOX")
+		if dir == "" {
+			dir = "./outbox"
+		}
+		return &mailer.FileOutbox{Dir: dir, From: from}
+	}
+}
+
+// newOIDCProviders reads the providers named in BLOGGER_OIDC_PROVIDERS. Each
+// one is configured with BLOGGER_OIDC_<NAME>_ISSUER, _CLIENT_ID and
+// _CLIENT_SECRET.
+func newOIDCProviders(publicURL string) oidc.Providers {
+	providers := oidc.Providers{}
+	for _, name := range strings.Split(os.Getenv("BLOGGER_OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "BLOGGER_OIDC_" + strings.ToUpper(name) + "_"
+		issuer := os.Getenv(prefix + "ISSUER")
+		clientID := os.Getenv(prefix + "CLIENT_ID")
+		if issuer == "" || clientID == "" {
+			fmt.Printf("Skipping OIDC provider %s: %sISSUER and %sCLIENT_ID are required\n", name, prefix, prefix)
+			continue
+		}
+
+		providers[name] = oidc.NewProvider(oidc.Config{
+			Name:         name,
+			Issuer:       issuer,
+			ClientID:     clientID,
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  publicURL + "/auth/oidc/" + name + "/callback",
+		}, nil)
+	}
+
+	return providers
+}
+
+// newPasskeyStore sets up WebAuthn for BLOGGER_WEBAUTHN_RP_ID (the host of the
+// public URL by default), accepting the comma separated origins of
+// BLOGGER_WEBAUTHN_ORIGINS (the public URL by default).
+func newPasskeyStore(db *gorm.DB, publicURL string) (*passkeys.Store, error) {
+	rpID := os.Getenv("BLOGGER_WEBAUTHN_RP_ID")
+	if rpID == "" {
+		u, err := url.Parse(publicURL)
+		if err != nil {
+			return nil, err
+		}
+		rpID = u.Hostname()
+	}
+
+	var origins []string
+	for _, origin := range strings.Split(os.Getenv("BLOGGER_WEBAUTHN_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	if len(origins) == 0 {
+		origins = []string{publicURL}
+	}
+
+	return passkeys.NewStore(db, rpID, "Blogger", origins)
+}
+
 func main() {
 	println("Starting Blogger Backend...")
 	println("Loading dotenv...")
@@ -23,11 +157,10 @@ func main() {
 		fmt.Println("Error loading .env file")
 	}
 
-	secret := os.Getenv("BLOGGER_JWT_SECRET")
 	dsn := os.Getenv("BLOGGER_GORM_DATABASE_STRING")
 
 	println("Connecting to database...")
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{TranslateError: true})
 	if err != nil {
 		fmt.Printf("Failed to open connection to database: %s", err.Error())
 		os.Exit(1)
@@ -35,17 +168,160 @@ func main() {
 	println("Successfully connected to database.")
 
 	println("Running migrations...")
-	err = db.AutoMigrate(&models.User{}, &models.Post{}, &models.Like{})
+	err = db.AutoMigrate(models.All()...)
 	if err != nil {
 		fmt.Printf("Failed to migrate users: %s", err.Error())
 		os.Exit(1)
 	}
 
+	// Posts from before publish states and slugs are filled in by
+	// blogger-admin, not on every start.
+	var pending []uint
+	db.Unscoped().Model(&models.Post{}).
+		Where("(status = ? AND published_at IS NULL) OR slug IS NULL", publishing.StatusPublished).
+		Limit(1).Pluck("id", &pending)
+	if len(pending) > 0 {
+		fmt.Println("Warning: some posts have no publish date or slug yet; run \"blogger-admin backfill\" once.")
+	}
+
+	password.Configure(password.NewArgon2id(password.Argon2idParams{
+		Memory:      uint32(getEnvInt("BLOGGER_ARGON2_MEMORY", int(password.DefaultArgon2idParams.Memory))),
+		Iterations:  uint32(getEnvInt("BLOGGER_ARGON2_ITERATIONS", int(password.DefaultArgon2idParams.Iterations))),
+		Parallelism: uint8(getEnvInt("BLOGGER_ARGON2_PARALLELISM", int(password.DefaultArgon2idParams.Parallelism))),
+		SaltLength:  password.DefaultArgon2idParams.SaltLength,
+		KeyLength:   password.DefaultArgon2idParams.KeyLength,
+	}))
+
+	policy := &password.Policy{
+		MinLength:  getEnvInt("BLOGGER_PASSWORD_MIN_LENGTH", password.DefaultPolicy.MinLength),
+		MaxLength:  password.DefaultPolicy.MaxLength,
+		MinEntropy: password.DefaultPolicy.MinEntropy,
+	}
+	if path := os.Getenv("BLOGGER_BREACHED_PASSWORDS_FILE"); path != "" {
+		println("Loading breached passwords list...")
+		policy.Breached, err = password.LoadBreachedList(path)
+		if err != nil {
+			fmt.Printf("Failed to load breached passwords list: %s\n", err.Error())
+			os.Exit(1)
+		}
+		fmt.Printf("Loaded %d breached password hashes.\n", policy.Breached.Len())
+	}
+	password.ConfigurePolicy(policy)
+
 	println("Setting up Fiber...")
-	ah := routes.NewAuthHandler(db, secret)
+	var revoked revocation.Store
+	if os.Getenv("BLOGGER_REVOCATION_STORE") == "memory" {
+		revoked = revocation.NewMemoryStore(time.Minute)
+	} else {
+		revoked = revocation.NewPostgresStore(db, 10*time.Minute)
+	}
+
+	var attempts lockout.Store
+	if os.Getenv("BLOGGER_LOCKOUT_STORE") == "memory" {
+		attempts = lockout.NewMemoryStore(time.Minute, 2*time.Hour)
+	} else {
+		attempts = lockout.NewPostgresStore(db, 10*time.Minute, 2*time.Hour)
+	}
+
+	println("Loading signing keys...")
+	algorithm := os.Getenv("BLOGGER_JWT_ALGORITHM")
+	if algorithm == "" {
+		algorithm = jwt.AlgorithmEdDSA
+	}
+
+	// BLOGGER_JWT_SECRET is only used to accept HS256 tokens issued before
+	// the switch to asymmetric keys, and only until BLOGGER_JWT_LEGACY_UNTIL.
+	legacySecret := os.Getenv("BLOGGER_JWT_SECRET")
+	var legacyUntil time.Time
+	if legacySecret != "" {
+		legacyUntil, err = time.Parse(time.RFC3339, os.Getenv("BLOGGER_JWT_LEGACY_UNTIL"))
+		switch {
+		case err != nil:
+			fmt.Println("Warning: BLOGGER_JWT_SECRET is deprecated and ignored without a valid BLOGGER_JWT_LEGACY_UNTIL (RFC 3339); HS256 tokens are rejected.")
+		case time.Now().Before(legacyUntil):
+			fmt.Printf("Warning: BLOGGER_JWT_SECRET is deprecated; HS256 tokens are accepted until %s.\n", legacyUntil.Format(time.RFC3339))
+		default:
+			fmt.Println("Warning: BLOGGER_JWT_LEGACY_UNTIL has passed; remove BLOGGER_JWT_SECRET.")
+		}
+	}
+	keys, err := jwt.NewKeyRing(jwt.NewDBKeyStore(db), algorithm, legacySecret, legacyUntil)
+	if err != nil {
+		fmt.Printf("Failed to load signing keys: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	rotation := getEnvDuration("BLOGGER_JWT_KEY_ROTATION", 30*24*time.Hour)
+	overlap := getEnvDuration("BLOGGER_JWT_KEY_OVERLAP", 24*time.Hour)
+	keys.StartRotation(rotation, overlap)
+
+	tokenSecret := os.Getenv("BLOGGER_TOKEN_SECRET")
+	if tokenSecret == "" {
+		fmt.Println("BLOGGER_TOKEN_SECRET is not set, emailed links will not survive a restart")
+		tokenSecret, _ = helpers.RandomToken(32)
+	}
+	oneTimeTokens := onetime.NewStore(db, []byte(tokenSecret))
+
+	publicURL := os.Getenv("BLOGGER_PUBLIC_URL")
+	if publicURL == "" {
+		publicURL = "http://localhost:3000"
+	}
+
+	sessionStore := sessions.NewStore(db, 30*24*time.Hour, revoked)
+	guard := lockout.NewGuard(attempts)
+	mail := newMailer()
+	registrationMode := os.Getenv("BLOGGER_REGISTRATION_MODE")
+	if registrationMode == "" {
+		registrationMode = invites.ModeOpen
+	}
+	inviteStore, err := invites.NewStore(db, registrationMode, strings.Split(os.Getenv("BLOGGER_REGISTRATION_DOMAINS"), ","))
+	if err != nil {
+		fmt.Printf("Invalid BLOGGER_REGISTRATION_MODE: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	ah := routes.NewAuthHandler(db, keys, sessionStore, oneTimeTokens, mail, guard, inviteStore, publicURL)
+	ah.MagicLinkSignup = os.Getenv("BLOGGER_MAGIC_LINK_SIGNUP") == "true"
+	// BLOGGER_PUBLIC_URL is where this API is served. Reset links open a
+	// front-end page instead, since resetting takes a form.
+	if resetURL := os.Getenv("BLOGGER_PASSWORD_RESET_URL"); resetURL != "" {
+		ah.PasswordResetURL = resetURL
+	}
 	uh := routes.NewUserHandler(db)
-	ph := routes.NewPostsHandler(db)
-	sh := routes.NewSettingsHandler(db)
+	purger := trash.NewPurger(db, getEnvDuration("BLOGGER_TRASH_RETENTION", 30*24*time.Hour), time.Hour)
+	ph := routes.NewPostsHandler(db, purger)
+	scheduler := publishing.NewScheduler(db)
+	scheduler.Start(time.Minute)
+	sh := routes.NewSettingsHandler(db, sessionStore, keys, guard, oneTimeTokens, mail, publicURL)
+	sesh := routes.NewSessionsHandler(sessionStore)
+	mh := routes.NewMFAHandler(db)
+	oh := routes.NewOIDCHandler(ah, newOIDCProviders(publicURL))
+
+	passkeyStore, err := newPasskeyStore(db, publicURL)
+	if err != nil {
+		fmt.Printf("Failed to set up passkeys: %s\n", err.Error())
+		os.Exit(1)
+	}
+	pkh := routes.NewPasskeysHandler(ah, passkeyStore)
+	adh := routes.NewAdminHandler(db, keys, sessionStore)
+	modh := routes.NewModerationHandler(db, sessionStore)
+
+	tokenStore := pat.NewStore(db)
+	th := routes.NewTokensHandler(tokenStore)
+	ih := routes.NewInvitesHandler(inviteStore, getEnvInt("BLOGGER_INVITES_PER_USER", 5))
+
+	notSuspended := moderation.Check(db)
+	authRequired := pat.Middleware(tokenStore, jwt.JwtMiddleware(keys, revocation.Check(revoked), sessionStore.Check, notSuspended), notSuspended)
+	sessionRequired := jwt.RequireSession()
+	recentMFARequired := jwt.RequireRecentAuth(sessions.MethodMFA, 10*time.Minute)
+	notImpersonating := jwt.RejectImpersonation()
+	authOptional := jwt.Optional(authRequired)
+
+	// Unverified accounts may sign in but not publish or like unless this is
+	// switched off.
+	verifiedRequired := func(c *fiber.Ctx) error { return c.Next() }
+	if os.Getenv("BLOGGER_REQUIRE_VERIFIED_EMAIL") == "true" {
+		verifiedRequired = routes.RequireVerified(db)
+	}
 
 	app := fiber.New(fiber.Config{
 		DisableStartupMessage: false,
@@ -57,24 +333,99 @@ func main() {
 	authGroup := app.Group("/auth")
 	authGroup.Post("/register", ah.Register)
 	authGroup.Post("/login", ah.Login)
+	authGroup.Post("/login/mfa", ah.LoginMFA)
 	authGroup.Post("/refresh", ah.Refresh)
 	authGroup.Post("/logout", ah.Logout)
+	authGroup.Get("/verify", ah.Verify)
+	authGroup.Post("/resend-verification", authRequired, sessionRequired, ah.ResendVerification)
+	authGroup.Post("/forgot-password", ah.ForgotPassword)
+	authGroup.Post("/magic-link", ah.MagicLink)
+	authGroup.Get("/magic-link/verify", ah.VerifyMagicLink)
+	authGroup.Post("/reauthenticate", authRequired, notImpersonating, sessionRequired, ah.Reauthenticate)
+	authGroup.Post("/reset-password", ah.ResetPassword)
+	authGroup.Post("/appeal", ah.Appeal)
+	authGroup.Post("/reauthenticate/oidc", authRequired, notImpersonating, sessionRequired, oh.Reauthenticate)
+	authGroup.Post("/reauthenticate/passkey/begin", authRequired, notImpersonating, sessionRequired, pkh.BeginReauthenticate)
+	authGroup.Post("/reauthenticate/passkey/finish", authRequired, notImpersonating, sessionRequired, pkh.FinishReauthenticate)
+	authGroup.Post("/passkey/begin", pkh.BeginLogin)
+	authGroup.Post("/passkey/finish", pkh.FinishLogin)
+	authGroup.Post("/login/mfa/passkey/begin", pkh.BeginMFA)
+	authGroup.Post("/login/mfa/passkey/finish", pkh.FinishMFA)
+	authGroup.Get("/oidc", oh.ListProviders)
+	authGroup.Get("/oidc/:provider", oh.Login)
+	authGroup.Get("/oidc/:provider/callback", oh.Callback)
 
 	// Users group
 	usersGroup := app.Group("/users")
-	usersGroup.Get("/get", uh.GetUser)
+	usersGroup.Get("/get", authOptional, uh.GetUser)
 	usersGroup.Get("/getLikes", uh.GetLikes)
 	usersGroup.Get("/getPosts", uh.GetUsersPosts)
 
+	app.Get("/@:username/:slug", authOptional, ph.GetBySlug)
+
 	postsGroup := app.Group("/posts")
-	postsGroup.Post("/create", jwt.JwtMiddleware(secret), ph.CreatePost)
-	postsGroup.Get("/get", ph.GetPost)
-	postsGroup.Post("/like", jwt.JwtMiddleware(secret), ph.Like)
+	postsGroup.Post("/create", authRequired, jwt.RequireScope(pat.ScopePostsWrite), verifiedRequired, ph.CreatePost)
+	postsGroup.Get("/get", authOptional, ph.GetPost)
+	postsGroup.Post("/update", authRequired, notImpersonating, jwt.RequireScope(pat.ScopePostsWrite), verifiedRequired, ph.UpdatePost)
+	postsGroup.Get("/revisions", authRequired, ph.Revisions)
+	postsGroup.Get("/revision", authRequired, ph.Revision)
+	postsGroup.Get("/diff", authRequired, ph.Diff)
+	postsGroup.Post("/status", authRequired, notImpersonating, jwt.RequireScope(pat.ScopePostsWrite), verifiedRequired, ph.SetStatus)
+	postsGroup.Get("/drafts", authRequired, ph.Drafts)
+	postsGroup.Post("/restore", authRequired, notImpersonating, jwt.RequireScope(pat.ScopePostsWrite), verifiedRequired, ph.RestoreRevision)
+	postsGroup.Post("/delete", authRequired, notImpersonating, jwt.RequireScope(pat.ScopePostsWrite), ph.DeletePost)
+	postsGroup.Get("/trash", authRequired, ph.Trash)
+	postsGroup.Post("/trash/restore", authRequired, notImpersonating, jwt.RequireScope(pat.ScopePostsWrite), ph.RestoreDeleted)
+	postsGroup.Post("/like", authRequired, jwt.RequireScope(pat.ScopeLikesWrite), verifiedRequired, ph.Like)
 
 	settingsGroup := app.Group("/settings")
-	settingsGroup.Post("/update-username", jwt.JwtMiddleware(secret), sh.UpdateUserName)
-	settingsGroup.Post("/update-displayname", jwt.JwtMiddleware(secret), sh.UpdateDisplayName)
-	settingsGroup.Post("/update-password", jwt.JwtMiddleware(secret), sh.UpdatePassword)
+	settingsGroup.Post("/update-username", authRequired, notImpersonating, sessionRequired, sh.UpdateUserName)
+	settingsGroup.Post("/update-displayname", authRequired, notImpersonating, jwt.RequireScope(pat.ScopeSettingsWrite), sh.UpdateDisplayName)
+	settingsGroup.Post("/update-password", authRequired, notImpersonating, sessionRequired, sh.UpdatePassword)
+	settingsGroup.Post("/update-email", authRequired, notImpersonating, sessionRequired, sh.UpdateEmail)
+	settingsGroup.Get("/confirm-email", sh.ConfirmEmail)
+	settingsGroup.Get("/cancel-email-change", sh.CancelEmailChange)
+	settingsGroup.Get("/identities", authRequired, sessionRequired, oh.Identities)
+	settingsGroup.Post("/identities/link", authRequired, notImpersonating, sessionRequired, oh.Link)
+	settingsGroup.Post("/identities/unlink", authRequired, notImpersonating, sessionRequired, oh.Unlink)
+	settingsGroup.Get("/passkeys", authRequired, sessionRequired, pkh.List)
+	settingsGroup.Post("/passkeys/begin", authRequired, notImpersonating, sessionRequired, pkh.BeginRegistration)
+	settingsGroup.Post("/passkeys/finish", authRequired, notImpersonating, sessionRequired, pkh.FinishRegistration)
+	settingsGroup.Post("/passkeys/remove", authRequired, notImpersonating, sessionRequired, pkh.Remove)
+	settingsGroup.Post("/mfa/setup", authRequired, notImpersonating, sessionRequired, mh.Setup)
+	settingsGroup.Post("/mfa/confirm", authRequired, notImpersonating, sessionRequired, mh.Confirm)
+	settingsGroup.Post("/mfa/disable", authRequired, notImpersonating, sessionRequired, recentMFARequired, mh.Disable)
+	settingsGroup.Post("/mfa/recovery-codes", authRequired, notImpersonating, sessionRequired, recentMFARequired, mh.RegenerateRecoveryCodes)
+
+	sessionsGroup := app.Group("/sessions")
+	sessionsGroup.Get("/list", authRequired, sessionRequired, sesh.List)
+	sessionsGroup.Post("/revoke", authRequired, notImpersonating, sessionRequired, sesh.Revoke)
+	sessionsGroup.Post("/revoke-others", authRequired, notImpersonating, sessionRequired, sesh.RevokeOthers)
+
+	tokensGroup := app.Group("/tokens")
+	tokensGroup.Post("/create", authRequired, notImpersonating, sessionRequired, th.Create)
+	tokensGroup.Get("/list", authRequired, sessionRequired, th.List)
+	tokensGroup.Post("/revoke", authRequired, notImpersonating, sessionRequired, th.Revoke)
+
+	invitesGroup := app.Group("/invites")
+	invitesGroup.Post("/create", authRequired, notImpersonating, sessionRequired, verifiedRequired, ih.Create)
+	invitesGroup.Get("/list", authRequired, sessionRequired, ih.List)
+	invitesGroup.Post("/revoke", authRequired, notImpersonating, sessionRequired, ih.Revoke)
+
+	manageRoles := jwt.RequirePermission(roles.PermManageRoles)
+	adminGroup := app.Group("/admin")
+	adminGroup.Get("/staff", authRequired, sessionRequired, manageRoles, adh.Staff)
+	adminGroup.Post("/users/role", authRequired, sessionRequired, manageRoles, adh.SetRole)
+	adminGroup.Post("/impersonate", authRequired, sessionRequired, notImpersonating, jwt.RequirePermission(roles.PermImpersonate), adh.Impersonate)
+	adminGroup.Post("/impersonate/stop", authRequired, adh.StopImpersonating)
+
+	moderateUsers := jwt.RequirePermission(roles.PermModerateUsers)
+	moderationGroup := app.Group("/moderation")
+	moderationGroup.Get("/suspensions", authRequired, sessionRequired, moderateUsers, modh.Suspensions)
+	moderationGroup.Post("/suspend", authRequired, sessionRequired, moderateUsers, modh.Suspend)
+	moderationGroup.Post("/unsuspend", authRequired, sessionRequired, moderateUsers, modh.Unsuspend)
+
+	app.Get("/.well-known/jwks.json", jwt.JWKSHandler(keys))
 
 	app.Get("/", func(c *fiber.Ctx) error {
 		return c.SendString("OK")
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// AuditLog records security-relevant events. UserID is the account affected
+// and ActorID whoever caused the event, when that is someone else.
+type AuditLog struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID    *uint     `gorm:"index:audit_logs_user_id_idx" json:"user_id"`
+	ActorID   *uint     `json:"actor_id"`
+	Action    string    `gorm:"type:text;not null;index:audit_logs_action_idx" json:"action"`
+	Detail    string    `gorm:"type:text;not null" json:"detail"`
+	IP        string    `gorm:"type:text;not null" json:"ip"`
+	CreatedAt time.Time `gorm:"type:timestamp;not null;default:now()" json:"created_at"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Identity links a user to an account at an external OpenID Connect
+// provider. A user may have several, with or without a password.
+type Identity struct {
+	ID         uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID     uint       `gorm:"not null;index:identities_user_id_idx" json:"-"`
+	Provider   string     `gorm:"type:text;not null;uniqueIndex:identities_provider_subject_idx" json:"provider"`
+	Subject    string     `gorm:"type:text;not null;uniqueIndex:identities_provider_subject_idx" json:"-"`
+	Email      string     `gorm:"type:text;not null;default:''" json:"email"`
+	CreatedAt  time.Time  `gorm:"type:timestamp;not null;default:now()" json:"created_at"`
+	LastUsedAt *time.Time `gorm:"type:timestamp" json:"last_used_at"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
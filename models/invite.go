@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// Invite lets up to MaxUses people register while registration is not open.
+// InviterID is nil for invites minted from the server's shell.
+type Invite struct {
+	ID        uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	InviterID *uint      `gorm:"index:invites_inviter_id_idx" json:"inviter_id"`
+	Prefix    string     `gorm:"type:text;not null" json:"prefix"`
+	CodeHash  string     `gorm:"type:text;not null;uniqueIndex:invites_code_hash_idx" json:"-"`
+	MaxUses   int        `gorm:"not null" json:"max_uses"`
+	Uses      int        `gorm:"not null;default:0" json:"uses"`
+	ExpiresAt *time.Time `gorm:"type:timestamp" json:"expires_at"`
+	CreatedAt time.Time  `gorm:"type:timestamp;not null;default:now()" json:"created_at"`
+	RevokedAt *time.Time `gorm:"type:timestamp" json:"-"`
+
+	// Relationships
+	Inviter  *User  `gorm:"foreignKey:InviterID" json:"-"`
+	Invitees []User `gorm:"foreignKey:InviteID" json:"-"`
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// LoginAttempt counts recent failed logins for a key such as an account or an
+// IP address.
+type LoginAttempt struct {
+	Key           string     `gorm:"primaryKey;type:text" json:"-"`
+	Failures      int        `gorm:"not null;default:0" json:"-"`
+	LastFailureAt time.Time  `gorm:"type:timestamp;not null" json:"-"`
+	LockedUntil   *time.Time `gorm:"type:timestamp" json:"-"`
+}
@@ -0,0 +1,28 @@
+package models
+
+// All lists every model in migration order.
+func All() []any {
+	return []any{
+		&User{},
+		&Invite{},
+		&Post{},
+		&PostRevision{},
+		&PostSlugHistory{},
+		&UsernameHistory{},
+		&Like{},
+		&Session{},
+		&RefreshToken{},
+		&RevokedToken{},
+		&SigningKey{},
+		&PersonalAccessToken{},
+		&OneTimeToken{},
+		&RecoveryCode{},
+		&LoginAttempt{},
+		&AuditLog{},
+		&Identity{},
+		&OIDCState{},
+		&Passkey{},
+		&WebAuthnChallenge{},
+		&Suspension{},
+	}
+}
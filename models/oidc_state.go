@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// OIDCState remembers an authorization request sent to a provider until the
+// browser comes back with its state. UserID and SessionID are set when a
+// signed-in user links an identity or reauthenticates.
+type OIDCState struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"-"`
+	StateHash string    `gorm:"type:text;not null;uniqueIndex:oidc_states_hash_idx" json:"-"`
+	Provider  string    `gorm:"type:text;not null" json:"-"`
+	Purpose   string    `gorm:"type:text;not null" json:"-"`
+	Nonce     string    `gorm:"type:text;not null" json:"-"`
+	Verifier  string    `gorm:"type:text;not null" json:"-"`
+	UserID    *uint     `json:"-"`
+	SessionID *uint     `json:"-"`
+	ExpiresAt time.Time `gorm:"type:timestamp;not null" json:"-"`
+	CreatedAt time.Time `gorm:"type:timestamp;not null;default:now()" json:"-"`
+}
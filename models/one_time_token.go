@@ -0,0 +1,20 @@
+package models
+
+import "time"
+
+// OneTimeToken is a single-use token sent by email, e.g. to verify an
+// address. Purpose keeps tokens of different flows from being interchangeable.
+// UserID is nil for tokens sent to addresses without an account yet.
+type OneTimeToken struct {
+	ID        uint       `gorm:"primaryKey;autoIncrement" json:"-"`
+	UserID    *uint      `gorm:"index:one_time_tokens_user_id_idx" json:"-"`
+	Purpose   string     `gorm:"type:text;not null" json:"-"`
+	Email     string     `gorm:"type:text;not null" json:"-"`
+	TokenHash string     `gorm:"type:text;not null;uniqueIndex:one_time_tokens_hash_idx" json:"-"`
+	ExpiresAt time.Time  `gorm:"type:timestamp;not null" json:"-"`
+	UsedAt    *time.Time `gorm:"type:timestamp" json:"-"`
+	CreatedAt time.Time  `gorm:"type:timestamp;not null;default:now()" json:"-"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import "time"
+
+// Passkey is a WebAuthn credential of a user. Credential holds the JSON
+// encoded webauthn.Credential, including its signature counter.
+type Passkey struct {
+	ID           uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID       uint       `gorm:"not null;index:passkeys_user_id_idx" json:"-"`
+	Name         string     `gorm:"type:text;not null" json:"name"`
+	CredentialID []byte     `gorm:"type:bytea;not null;uniqueIndex:passkeys_credential_id_idx" json:"-"`
+	Credential   string     `gorm:"type:text;not null" json:"-"`
+	CreatedAt    time.Time  `gorm:"type:timestamp;not null;default:now()" json:"created_at"`
+	LastUsedAt   *time.Time `gorm:"type:timestamp" json:"last_used_at"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// PersonalAccessToken is a long-lived token for scripts and CI, limited to a
+// set of scopes.
+type PersonalAccessToken struct {
+	ID         uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID     uint       `gorm:"not null;index:personal_access_tokens_user_id_idx" json:"-"`
+	Name       string     `gorm:"type:text;not null" json:"name"`
+	Prefix     string     `gorm:"type:text;not null" json:"prefix"`
+	TokenHash  string     `gorm:"type:text;not null;uniqueIndex:personal_access_tokens_hash_idx" json:"-"`
+	Scopes     string     `gorm:"type:text;not null" json:"scopes"`
+	ExpiresAt  *time.Time `gorm:"type:timestamp" json:"expires_at"`
+	LastUsedAt *time.Time `gorm:"type:timestamp" json:"last_used_at"`
+	CreatedAt  time.Time  `gorm:"type:timestamp;not null;default:now()" json:"created_at"`
+	RevokedAt  *time.Time `gorm:"type:timestamp" json:"-"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
@@ -1,16 +1,39 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
 
 type Post struct {
 	ID          uint      `gorm:"primaryKey;autoIncrement" json:"id"`
-	UserID      uint      `gorm:"not null;index:posts_user_id_idx" json:"-"`
+	UserID      uint      `gorm:"not null;index:posts_user_id_idx;uniqueIndex:posts_user_slug_idx,priority:1" json:"-"`
 	Title       string    `gorm:"type:text;not null;index:posts_index_0" json:"title"`
 	CreatedAt   time.Time `gorm:"type:timestamp;not null;default:now()" json:"created_at"`
 	Description string    `gorm:"type:text;not null" json:"description"`
 	Content     string    `gorm:"type:text;not null" json:"content"`
 
+	// Slug addresses the post under its author as /@username/slug. It is nil
+	// only for posts from before slugs, until they are backfilled.
+	Slug *string `gorm:"type:text;uniqueIndex:posts_user_slug_idx,priority:2" json:"slug"`
+
+	// Status is one of the publishing package's states. PublishedAt is when
+	// the post first went public and ScheduledAt when a scheduled post will.
+	Status      string     `gorm:"type:text;not null;default:published;index:posts_status_idx" json:"status"`
+	PublishedAt *time.Time `gorm:"type:timestamp;index:posts_published_at_idx" json:"published_at"`
+	ScheduledAt *time.Time `gorm:"type:timestamp;index:posts_scheduled_at_idx" json:"scheduled_at,omitempty"`
+
+	// EditedAt is when the post was last edited, nil if it never was.
+	EditedAt *time.Time `gorm:"type:timestamp" json:"edited_at"`
+
+	// DeletedAt puts the post in the author's trash. GORM leaves such posts
+	// out of every query that is not Unscoped.
+	DeletedAt gorm.DeletedAt `gorm:"index:posts_deleted_at_idx" json:"-"`
+
 	// Relationships
-	User  User   `gorm:"foreignKey:UserID" json:"user"`
-	Likes []Like `gorm:"foreignKey:PostID" json:"-"`
+	User      User              `gorm:"foreignKey:UserID" json:"user"`
+	Likes     []Like            `gorm:"foreignKey:PostID" json:"-"`
+	Revisions []PostRevision    `gorm:"foreignKey:PostID" json:"-"`
+	Slugs     []PostSlugHistory `gorm:"foreignKey:PostID" json:"-"`
 }
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// PostRevision is a saved version of a post. Number counts the versions of
+// the post from 1, the version it was published with.
+type PostRevision struct {
+	ID          uint      `gorm:"primaryKey;autoIncrement" json:"-"`
+	PostID      uint      `gorm:"not null;uniqueIndex:post_revisions_post_number_idx,priority:1" json:"-"`
+	Number      int       `gorm:"not null;uniqueIndex:post_revisions_post_number_idx,priority:2" json:"number"`
+	Title       string    `gorm:"type:text;not null" json:"title"`
+	Description string    `gorm:"type:text;not null" json:"description"`
+	Content     string    `gorm:"type:text;not null" json:"content,omitempty"`
+	EditorID    uint      `gorm:"not null" json:"editor_id"`
+	CreatedAt   time.Time `gorm:"type:timestamp;not null;default:now()" json:"created_at"`
+
+	// RestoredFrom is the number of the revision this one brought back.
+	RestoredFrom *int `json:"restored_from,omitempty"`
+
+	// Relationships
+	Post   Post `gorm:"foreignKey:PostID" json:"-"`
+	Editor User `gorm:"foreignKey:EditorID" json:"-"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// RecoveryCode is a one-time code that replaces the TOTP code when the
+// authenticator is lost.
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey;autoIncrement" json:"-"`
+	UserID    uint       `gorm:"not null;index:recovery_codes_user_id_idx" json:"-"`
+	CodeHash  string     `gorm:"type:text;not null" json:"-"`
+	UsedAt    *time.Time `gorm:"type:timestamp" json:"-"`
+	CreatedAt time.Time  `gorm:"type:timestamp;not null;default:now()" json:"-"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// RefreshToken is a single link in the rotating chain of refresh tokens of a
+// session.
+type RefreshToken struct {
+	ID        uint       `gorm:"primaryKey;autoIncrement" json:"-"`
+	UserID    uint       `gorm:"not null;index:refresh_tokens_user_id_idx" json:"-"`
+	SessionID uint       `gorm:"not null;index:refresh_tokens_session_id_idx" json:"-"`
+	ParentID  *uint      `json:"-"`
+	TokenHash string     `gorm:"type:text;not null;uniqueIndex:refresh_tokens_hash_idx" json:"-"`
+	ExpiresAt time.Time  `gorm:"type:timestamp;not null" json:"-"`
+	UsedAt    *time.Time `gorm:"type:timestamp" json:"-"`
+	RevokedAt *time.Time `gorm:"type:timestamp" json:"-"`
+	CreatedAt time.Time  `gorm:"type:timestamp;not null;default:now()" json:"-"`
+
+	// Relationships
+	User    User          `gorm:"foreignKey:UserID" json:"-"`
+	Session Session       `gorm:"foreignKey:SessionID" json:"-"`
+	Parent  *RefreshToken `gorm:"foreignKey:ParentID" json:"-"`
+}
@@ -0,0 +1,10 @@
+package models
+
+import "time"
+
+// RevokedToken marks an access token as revoked until it would have expired
+// anyway.
+type RevokedToken struct {
+	JTI       string    `gorm:"primaryKey;type:text" json:"-"`
+	ExpiresAt time.Time `gorm:"type:timestamp;not null;index:revoked_tokens_expires_at_idx" json:"-"`
+}
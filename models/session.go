@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+// Session is a single signed-in device. Refresh tokens and access tokens are
+// issued on behalf of a session and stop working once it is revoked.
+type Session struct {
+	ID          uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID      uint       `gorm:"not null;index:sessions_user_id_idx" json:"-"`
+	DeviceLabel string     `gorm:"type:text;not null" json:"device_label"`
+	UserAgent   string     `gorm:"type:text;not null" json:"user_agent"`
+	IP          string     `gorm:"type:text;not null" json:"ip"`
+	LastJTI     string     `gorm:"type:text;not null" json:"-"`
+	JTIExpires  *time.Time `gorm:"type:timestamp" json:"-"`
+	AMR         string     `gorm:"type:text;not null;default:''" json:"-"`
+	AuthTime    time.Time  `gorm:"type:timestamp;not null;default:now()" json:"-"`
+	CreatedAt   time.Time  `gorm:"type:timestamp;not null;default:now()" json:"created_at"`
+	LastSeenAt  time.Time  `gorm:"type:timestamp;not null;default:now()" json:"last_seen_at"`
+	ExpiresAt   time.Time  `gorm:"type:timestamp;not null" json:"expires_at"`
+	RevokedAt   *time.Time `gorm:"type:timestamp" json:"-"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// SigningKey is a private key used to sign access tokens. Retired keys no
+// longer sign but are kept for verification until the overlap window ends.
+type SigningKey struct {
+	ID         string     `gorm:"primaryKey;type:text" json:"-"`
+	Algorithm  string     `gorm:"type:text;not null" json:"-"`
+	PrivateKey string     `gorm:"type:text;not null" json:"-"`
+	CreatedAt  time.Time  `gorm:"type:timestamp;not null;default:now()" json:"-"`
+	RetiredAt  *time.Time `gorm:"type:timestamp" json:"-"`
+}
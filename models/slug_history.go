@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// PostSlugHistory is a slug a post had before its title changed. Requests for
+// it are redirected to the current slug.
+type PostSlugHistory struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"-"`
+	PostID    uint      `gorm:"not null;index:post_slug_histories_post_id_idx" json:"-"`
+	UserID    uint      `gorm:"not null;uniqueIndex:post_slug_histories_user_slug_idx,priority:1" json:"-"`
+	Slug      string    `gorm:"type:text;not null;uniqueIndex:post_slug_histories_user_slug_idx,priority:2" json:"slug"`
+	CreatedAt time.Time `gorm:"type:timestamp;not null;default:now()" json:"created_at"`
+}
+
+// UsernameHistory is a username a user had before renaming. Links to their
+// posts under it are redirected until someone else takes the name.
+type UsernameHistory struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"-"`
+	UserID    uint      `gorm:"not null;index:username_histories_user_id_idx" json:"-"`
+	Username  string    `gorm:"type:text;not null;unique" json:"username"`
+	CreatedAt time.Time `gorm:"type:timestamp;not null;default:now()" json:"created_at"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+// Suspension keeps a user from signing in until ExpiresAt, or for good if it
+// is nil, unless a moderator lifts it earlier. Rows are kept after they end
+// as the record of the user's past suspensions.
+type Suspension struct {
+	ID          uint       `gorm:"primaryKey;autoIncrement" json:"id"`
+	UserID      uint       `gorm:"not null;index:suspensions_user_id_idx" json:"-"`
+	ModeratorID uint       `gorm:"not null" json:"moderator_id"`
+	Reason      string     `gorm:"type:text;not null" json:"reason"`
+	HidePosts   bool       `gorm:"not null;default:false" json:"hide_posts"`
+	ExpiresAt   *time.Time `gorm:"type:timestamp" json:"expires_at"`
+	CreatedAt   time.Time  `gorm:"type:timestamp;not null;default:now()" json:"created_at"`
+
+	// Appeal is the user's single statement against the suspension.
+	Appeal     *string    `gorm:"type:text" json:"appeal"`
+	AppealedAt *time.Time `gorm:"type:timestamp" json:"appealed_at"`
+
+	LiftedAt   *time.Time `gorm:"type:timestamp" json:"lifted_at"`
+	LiftedByID *uint      `json:"lifted_by_id"`
+	LiftNote   *string    `gorm:"type:text" json:"lift_note"`
+
+	// Relationships
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
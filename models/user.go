@@ -5,16 +5,36 @@ import (
 )
 
 type User struct {
-	ID           uint      `gorm:"primaryKey;autoInrement" json:"-"`
-	DisplayName  *string   `gorm:"type:text" json:"display_name"`
-	Username     string    `gorm:"type:text;unique;not null;index:users_index_0" json:"username"`
-	Email        string    `gorm:"type:text;unique;not null" json:"-"`
-	About        *string   `gorm:"type:text" json:"about"`
-	CreatedAt    time.Time `gorm:"type:timestamp;not null;default:now()" json:"created_at"`
-	PasswordHash string    `gorm:"type:text;not null" json:"-"`
-	RefreshToken *string   `gorm:"type:text" json:"-"`
+	ID           uint       `gorm:"primaryKey;autoInrement" json:"-"`
+	DisplayName  *string    `gorm:"type:text" json:"display_name"`
+	Username     string     `gorm:"type:text;unique;not null;index:users_index_0" json:"username"`
+	Email        string     `gorm:"type:text;unique;not null" json:"-"`
+	About        *string    `gorm:"type:text" json:"about"`
+	CreatedAt    time.Time  `gorm:"type:timestamp;not null;default:now()" json:"created_at"`
+	PasswordHash string     `gorm:"type:text;not null" json:"-"` // empty for accounts that only sign in through a provider
+	VerifiedAt   *time.Time `gorm:"type:timestamp" json:"-"`
+	PendingEmail *string    `gorm:"type:text" json:"-"`
+	InviteID     *uint      `gorm:"index:users_invite_id_idx" json:"-"`
+
+	// Role is one of the roles package's roles. Permissions holds extra
+	// permissions granted on top of it, separated by spaces. Neither is
+	// public; the user and role managers see them in their own views.
+	Role        string `gorm:"type:text;not null;default:user;index:users_role_idx" json:"-"`
+	Permissions string `gorm:"type:text;not null;default:''" json:"-"`
+
+	// Two-factor authentication. TOTPSecret is set during enrollment and
+	// only takes effect once TOTPEnabledAt is set.
+	TOTPSecret      *string    `gorm:"type:text" json:"-"`
+	TOTPEnabledAt   *time.Time `gorm:"type:timestamp" json:"-"`
+	TOTPLastCounter int64      `gorm:"not null;default:0" json:"-"`
+
+	// WebAuthnID is the random user handle passkeys are bound to, set when
+	// the first one is registered.
+	WebAuthnID []byte `gorm:"column:webauthn_id;type:bytea;uniqueIndex:users_webauthn_id_idx" json:"-"`
 
 	// Relationships
-	Posts []Post `gorm:"foreignKey:UserID" json:"-"`
-	Likes []Like `gorm:"foreignKey:UserID" json:"-"`
+	Posts      []Post     `gorm:"foreignKey:UserID" json:"-"`
+	Likes      []Like     `gorm:"foreignKey:UserID" json:"-"`
+	Identities []Identity `gorm:"foreignKey:UserID" json:"-"`
+	Passkeys   []Passkey  `gorm:"foreignKey:UserID" json:"-"`
 }
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+// WebAuthnChallenge keeps the state of a WebAuthn ceremony between the
+// request for options and the authenticator's response. UserID is nil for
+// sign ins where the user is not known yet.
+type WebAuthnChallenge struct {
+	ID          uint      `gorm:"primaryKey;autoIncrement" json:"-"`
+	HandleHash  string    `gorm:"type:text;not null;uniqueIndex:webauthn_challenges_hash_idx" json:"-"`
+	Ceremony    string    `gorm:"type:text;not null" json:"-"`
+	UserID      *uint     `json:"-"`
+	SessionData string    `gorm:"type:text;not null" json:"-"`
+	ExpiresAt   time.Time `gorm:"type:timestamp;not null" json:"-"`
+	CreatedAt   time.Time `gorm:"type:timestamp;not null;default:now()" json:"-"`
+}
@@ -0,0 +1,161 @@
+// Package moderation suspends accounts and keeps the record of it.
+package moderation
+
+import (
+	"errors"
+	"time"
+
+	"github.com/kostya-zero/blogger/jwt"
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/sessions"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrSuspended        = errors.New("account is suspended")
+	ErrNotSuspended     = errors.New("account is not suspended")
+	ErrAlreadySuspended = errors.New("account is already suspended")
+	ErrAlreadyAppealed  = errors.New("suspension was already appealed")
+)
+
+// active limits a query to suspensions in force.
+func active(db *gorm.DB) *gorm.DB {
+	return db.Where("lifted_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", time.Now())
+}
+
+// Active returns the suspension of the user in force, or nil if there is
+// none.
+func Active(db *gorm.DB, userID uint) (*models.Suspension, error) {
+	var suspensions []models.Suspension
+	if err := active(db).Where("user_id = ?", userID).Limit(1).Find(&suspensions).Error; err != nil {
+		return nil, err
+	}
+	if len(suspensions) == 0 {
+		return nil, nil
+	}
+	return &suspensions[0], nil
+}
+
+// History returns every suspension of the user, latest first.
+func History(db *gorm.DB, userID uint) ([]models.Suspension, error) {
+	var suspensions []models.Suspension
+	err := db.Where("user_id = ?", userID).Order("created_at DESC").Find(&suspensions).Error
+	return suspensions, err
+}
+
+// Suspend suspends the user until the given time, or permanently if it is
+// nil, and signs them out everywhere.
+func Suspend(db *gorm.DB, store *sessions.Store, userID, moderatorID uint, reason string, until *time.Time, hidePosts bool) (*models.Suspension, error) {
+	suspension := models.Suspension{
+		UserID:      userID,
+		ModeratorID: moderatorID,
+		Reason:      reason,
+		HidePosts:   hidePosts,
+		ExpiresAt:   until,
+		CreatedAt:   time.Now(),
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// The lock serializes suspensions of the same user.
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.User{}, userID).Error; err != nil {
+			return err
+		}
+
+		current, err := Active(tx, userID)
+		if err != nil {
+			return err
+		}
+		if current != nil {
+			return ErrAlreadySuspended
+		}
+
+		return tx.Create(&suspension).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := store.RevokeUser(userID); err != nil {
+		return nil, err
+	}
+
+	return &suspension, nil
+}
+
+// Lift ends the suspension of the user in force early.
+func Lift(db *gorm.DB, userID, moderatorID uint, note string) (*models.Suspension, error) {
+	current, err := Active(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return nil, ErrNotSuspended
+	}
+
+	now := time.Now()
+	result := db.Model(current).Where("lifted_at IS NULL").Updates(map[string]any{
+		"lifted_at":    now,
+		"lifted_by_id": moderatorID,
+		"lift_note":    note,
+	})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrNotSuspended
+	}
+
+	current.LiftedAt = &now
+	current.LiftedByID = &moderatorID
+	current.LiftNote = &note
+	return current, nil
+}
+
+// Appeal records the user's statement against the suspension in force. Each
+// suspension can be appealed once.
+func Appeal(db *gorm.DB, userID uint, statement string) (*models.Suspension, error) {
+	current, err := Active(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if current == nil {
+		return nil, ErrNotSuspended
+	}
+
+	now := time.Now()
+	result := db.Model(current).Where("appealed_at IS NULL").Updates(map[string]any{
+		"appeal":      statement,
+		"appealed_at": now,
+	})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrAlreadyAppealed
+	}
+
+	current.Appeal = &statement
+	current.AppealedAt = &now
+	return current, nil
+}
+
+// Check returns a jwt.Check that rejects tokens of suspended users.
+func Check(db *gorm.DB) jwt.Check {
+	return func(claims *jwt.TokenClaims) error {
+		var count int64
+		if err := active(db.Model(&models.Suspension{})).Where("user_id = ?", claims.UserID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrSuspended
+		}
+		return nil
+	}
+}
+
+// HiddenAuthors is a subquery of the users whose posts are hidden by their
+// suspension, for use in "user_id NOT IN (?)".
+func HiddenAuthors(db *gorm.DB) *gorm.DB {
+	return active(db.Model(&models.Suspension{})).Select("user_id").Where("hide_posts")
+}
@@ -0,0 +1,171 @@
+// Package onetime issues signed, single-use tokens for links sent by email.
+package onetime
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/kostya-zero/blogger/helpers"
+	"github.com/kostya-zero/blogger/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	PurposeVerifyEmail   = "verify_email"
+	PurposeResetPassword = "reset_password"
+	PurposeConfirmEmail  = "confirm_email_change"
+	PurposeCancelEmail   = "cancel_email_change"
+	PurposeMagicLogin    = "magic_login"
+)
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+type Store struct {
+	DB     *gorm.DB
+	Secret []byte
+}
+
+func NewStore(db *gorm.DB, secret []byte) *Store {
+	return &Store{DB: db, Secret: secret}
+}
+
+// Issue creates a token for the purpose. The returned string is
+// "<random>.<signature>"; the signature lets forged tokens be rejected
+// without touching the database.
+func (s *Store) Issue(userID uint, purpose, email string, ttl time.Duration) (string, error) {
+	return s.issue(&userID, purpose, email, ttl)
+}
+
+// IssueForEmail creates a token for an address that may not belong to any
+// user yet.
+func (s *Store) IssueForEmail(purpose, email string, ttl time.Duration) (string, error) {
+	return s.issue(nil, purpose, email, ttl)
+}
+
+func (s *Store) issue(userID *uint, purpose, email string, ttl time.Duration) (string, error) {
+	random, err := helpers.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	raw := random + "." + s.sign(purpose, random)
+	token := models.OneTimeToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		Email:     email,
+		TokenHash: helpers.HashToken(raw),
+		ExpiresAt: time.Now().Add(ttl),
+		CreatedAt: time.Now(),
+	}
+
+	if err := s.DB.Create(&token).Error; err != nil {
+		return "", err
+	}
+
+	return raw, nil
+}
+
+// Consume marks the token as used and returns it. A token can be consumed
+// only once.
+func (s *Store) Consume(raw, purpose string) (*models.OneTimeToken, error) {
+	var token *models.OneTimeToken
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		token, err = s.ConsumeTx(tx, raw, purpose)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// ConsumeTx is Consume within the caller's transaction, so that the token is
+// spent only if the rest of the transaction commits.
+func (s *Store) ConsumeTx(tx *gorm.DB, raw, purpose string) (*models.OneTimeToken, error) {
+	if !s.signed(raw, purpose) {
+		return nil, ErrInvalidToken
+	}
+
+	var token models.OneTimeToken
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("token_hash = ? AND purpose = ?", helpers.HashToken(raw), purpose).
+		First(&token).Error
+	if err != nil || !usable(&token) {
+		return nil, ErrInvalidToken
+	}
+
+	if err := tx.Model(&token).Update("used_at", time.Now()).Error; err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// Lookup returns the token if it is valid, without using it up.
+func (s *Store) Lookup(raw, purpose string) (*models.OneTimeToken, error) {
+	if !s.signed(raw, purpose) {
+		return nil, ErrInvalidToken
+	}
+
+	var token models.OneTimeToken
+	err := s.DB.Where("token_hash = ? AND purpose = ?", helpers.HashToken(raw), purpose).First(&token).Error
+	if err != nil || !usable(&token) {
+		return nil, ErrInvalidToken
+	}
+
+	return &token, nil
+}
+
+func (s *Store) signed(raw, purpose string) bool {
+	random, signature, ok := strings.Cut(raw, ".")
+	return ok && hmac.Equal([]byte(signature), []byte(s.sign(purpose, random)))
+}
+
+func usable(token *models.OneTimeToken) bool {
+	return token.UsedAt == nil && time.Now().Before(token.ExpiresAt)
+}
+
+// IssuedSince counts tokens issued to the user for the purpose after since,
+// for throttling outgoing emails.
+func (s *Store) IssuedSince(userID uint, purpose string, since time.Time) (int64, error) {
+	var count int64
+	err := s.DB.Model(&models.OneTimeToken{}).
+		Where("user_id = ? AND purpose = ? AND created_at > ?", userID, purpose, since).
+		Count(&count).Error
+	return count, err
+}
+
+// IssuedToEmailSince counts tokens sent to the address for the purpose after
+// since, whether or not it belongs to a user.
+func (s *Store) IssuedToEmailSince(email, purpose string, since time.Time) (int64, error) {
+	var count int64
+	err := s.DB.Model(&models.OneTimeToken{}).
+		Where("email = ? AND purpose = ? AND created_at > ?", email, purpose, since).
+		Count(&count).Error
+	return count, err
+}
+
+// Invalidate marks every unused token of the user for the purpose as used.
+func (s *Store) Invalidate(userID uint, purpose string) error {
+	return s.InvalidateTx(s.DB, userID, purpose)
+}
+
+// InvalidateTx is Invalidate within the caller's transaction.
+func (s *Store) InvalidateTx(tx *gorm.DB, userID uint, purpose string) error {
+	return tx.Model(&models.OneTimeToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", time.Now()).Error
+}
+
+func (s *Store) sign(purpose, random string) string {
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write([]byte(purpose + ":" + random))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
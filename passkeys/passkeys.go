@@ -0,0 +1,350 @@
+// Package passkeys registers WebAuthn credentials and verifies assertions made
+// with them.
+package passkeys
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/kostya-zero/blogger/helpers"
+	"github.com/kostya-zero/blogger/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrCeremonyNotFound = errors.New("ceremony not found or expired")
+	ErrNoPasskeys       = errors.New("user has no passkeys")
+	ErrUnknownPasskey   = errors.New("unknown passkey")
+	ErrCloned           = errors.New("passkey signature counter went backwards")
+)
+
+// Ceremony kinds. Login is a sign in with a discoverable credential by a user
+// who is not known yet; Assert asks a known user to use one of their passkeys,
+// e.g. as a second factor.
+const (
+	CeremonyRegister = "register"
+	CeremonyLogin    = "login"
+	CeremonyAssert   = "assert"
+)
+
+const ceremonyTTL = 5 * time.Minute
+
+type Store struct {
+	DB       *gorm.DB
+	WebAuthn *webauthn.WebAuthn
+}
+
+// Assertion is a verified use of a passkey.
+type Assertion struct {
+	User         models.User
+	Passkey      models.Passkey
+	UserVerified bool
+}
+
+// NewStore returns a store for the relying party rpID, accepting responses
+// from the given origins.
+func NewStore(db *gorm.DB, rpID, rpName string, origins []string) (*Store, error) {
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: rpName,
+		RPOrigins:     origins,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Store{DB: db, WebAuthn: w}, nil
+}
+
+// account adapts a user and their passkeys to webauthn.User.
+type account struct {
+	user     *models.User
+	passkeys []models.Passkey
+	creds    []webauthn.Credential
+}
+
+func (a *account) WebAuthnID() []byte                         { return a.user.WebAuthnID }
+func (a *account) WebAuthnName() string                       { return a.user.Username }
+func (a *account) WebAuthnCredentials() []webauthn.Credential { return a.creds }
+
+func (a *account) WebAuthnDisplayName() string {
+	if a.user.DisplayName != nil {
+		return *a.user.DisplayName
+	}
+	return a.user.Username
+}
+
+func (s *Store) account(user *models.User) (*account, error) {
+	acc := &account{user: user}
+	if err := s.DB.Where("user_id = ?", user.ID).Order("created_at").Find(&acc.passkeys).Error; err != nil {
+		return nil, err
+	}
+
+	for _, p := range acc.passkeys {
+		var cred webauthn.Credential
+		if err := json.Unmarshal([]byte(p.Credential), &cred); err != nil {
+			return nil, fmt.Errorf("passkey %d: %w", p.ID, err)
+		}
+		acc.creds = append(acc.creds, cred)
+	}
+
+	return acc, nil
+}
+
+// List returns the passkeys of the user.
+func (s *Store) List(userID uint) ([]models.Passkey, error) {
+	var list []models.Passkey
+	err := s.DB.Where("user_id = ?", userID).Order("created_at").Find(&list).Error
+	return list, err
+}
+
+func (s *Store) Count(userID uint) (int64, error) {
+	var count int64
+	err := s.DB.Model(&models.Passkey{}).Where("user_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
+// BeginRegistration returns the options for navigator.credentials.create and
+// the handle of the ceremony to finish it with.
+func (s *Store) BeginRegistration(user *models.User) (*protocol.CredentialCreation, string, error) {
+	if len(user.WebAuthnID) == 0 {
+		id := make([]byte, 32)
+		if _, err := rand.Read(id); err != nil {
+			return nil, "", err
+		}
+		if err := s.DB.Model(user).Update("webauthn_id", id).Error; err != nil {
+			return nil, "", err
+		}
+		user.WebAuthnID = id
+	}
+
+	acc, err := s.account(user)
+	if err != nil {
+		return nil, "", err
+	}
+
+	creation, session, err := s.WebAuthn.BeginRegistration(acc,
+		webauthn.WithExclusions(webauthn.Credentials(acc.creds).CredentialDescriptors()),
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	handle, err := s.saveCeremony(CeremonyRegister, &user.ID, session)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return creation, handle, nil
+}
+
+// FinishRegistration verifies the authenticator's response to the ceremony
+// and stores the new passkey under name.
+func (s *Store) FinishRegistration(user *models.User, handle, name string, response []byte) (*models.Passkey, error) {
+	session, err := s.consumeCeremony(handle, CeremonyRegister, &user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		return nil, err
+	}
+
+	acc, err := s.account(user)
+	if err != nil {
+		return nil, err
+	}
+
+	cred, err := s.WebAuthn.CreateCredential(acc, *session, parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(cred)
+	if err != nil {
+		return nil, err
+	}
+
+	passkey := models.Passkey{
+		UserID:       user.ID,
+		Name:         name,
+		CredentialID: cred.ID,
+		Credential:   string(data),
+		CreatedAt:    time.Now(),
+	}
+	if err := s.DB.Create(&passkey).Error; err != nil {
+		return nil, err
+	}
+
+	return &passkey, nil
+}
+
+// BeginLogin returns the options for navigator.credentials.get. With a nil
+// user any discoverable passkey may answer; otherwise only the user's own.
+func (s *Store) BeginLogin(user *models.User) (*protocol.CredentialAssertion, string, error) {
+	var (
+		assertion *protocol.CredentialAssertion
+		session   *webauthn.SessionData
+		ceremony  = CeremonyLogin
+		userID    *uint
+		err       error
+	)
+
+	if user == nil {
+		assertion, session, err = s.WebAuthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	} else {
+		acc, accErr := s.account(user)
+		if accErr != nil {
+			return nil, "", accErr
+		}
+		if len(acc.creds) == 0 {
+			return nil, "", ErrNoPasskeys
+		}
+
+		ceremony, userID = CeremonyAssert, &user.ID
+		assertion, session, err = s.WebAuthn.BeginLogin(acc)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	handle, err := s.saveCeremony(ceremony, userID, session)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return assertion, handle, nil
+}
+
+// FinishLogin verifies an assertion for a ceremony started with BeginLogin
+// and records the use of the passkey. ceremony must match the kind of the
+// ceremony: CeremonyLogin for a nil user, CeremonyAssert otherwise.
+func (s *Store) FinishLogin(handle, ceremony string, response []byte) (*Assertion, error) {
+	session, err := s.consumeCeremony(handle, ceremony, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, err
+	}
+
+	var acc *account
+	var cred *webauthn.Credential
+	if ceremony == CeremonyLogin {
+		var found webauthn.User
+		found, cred, err = s.WebAuthn.ValidatePasskeyLogin(func(_, userHandle []byte) (webauthn.User, error) {
+			var user models.User
+			if err := s.DB.Where("webauthn_id = ?", userHandle).First(&user).Error; err != nil {
+				return nil, ErrUnknownPasskey
+			}
+			return s.account(&user)
+		}, *session, parsed)
+		if err != nil {
+			return nil, err
+		}
+		acc = found.(*account)
+	} else {
+		var user models.User
+		if err := s.DB.Where("webauthn_id = ?", session.UserID).First(&user).Error; err != nil {
+			return nil, ErrUnknownPasskey
+		}
+
+		if acc, err = s.account(&user); err != nil {
+			return nil, err
+		}
+		if cred, err = s.WebAuthn.ValidateLogin(acc, *session, parsed); err != nil {
+			return nil, err
+		}
+	}
+	if cred.Authenticator.CloneWarning {
+		return nil, ErrCloned
+	}
+
+	var passkey *models.Passkey
+	for i := range acc.passkeys {
+		if string(acc.passkeys[i].CredentialID) == string(cred.ID) {
+			passkey = &acc.passkeys[i]
+		}
+	}
+	if passkey == nil {
+		return nil, ErrUnknownPasskey
+	}
+
+	data, err := json.Marshal(cred)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	err = s.DB.Model(passkey).Updates(map[string]any{"credential": string(data), "last_used_at": now}).Error
+	if err != nil {
+		return nil, err
+	}
+	passkey.Credential = string(data)
+	passkey.LastUsedAt = &now
+
+	return &Assertion{User: *acc.user, Passkey: *passkey, UserVerified: cred.Flags.UserVerified}, nil
+}
+
+func (s *Store) saveCeremony(ceremony string, userID *uint, session *webauthn.SessionData) (string, error) {
+	handle, err := helpers.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	err = s.DB.Create(&models.WebAuthnChallenge{
+		HandleHash:  helpers.HashToken(handle),
+		Ceremony:    ceremony,
+		UserID:      userID,
+		SessionData: string(data),
+		ExpiresAt:   time.Now().Add(ceremonyTTL),
+	}).Error
+	if err != nil {
+		return "", err
+	}
+
+	return handle, nil
+}
+
+// consumeCeremony deletes the ceremony and returns its session data, so that
+// every challenge is answered at most once. A non-nil userID must match the
+// user the ceremony was started for.
+func (s *Store) consumeCeremony(handle, ceremony string, userID *uint) (*webauthn.SessionData, error) {
+	var rows []models.WebAuthnChallenge
+	err := s.DB.Clauses(clause.Returning{}).
+		Where("handle_hash = ? AND ceremony = ? AND expires_at > ?", helpers.HashToken(handle), ceremony, time.Now()).
+		Delete(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, ErrCeremonyNotFound
+	}
+
+	row := rows[0]
+	if userID != nil && (row.UserID == nil || *row.UserID != *userID) {
+		return nil, ErrCeremonyNotFound
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(row.SessionData), &session); err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
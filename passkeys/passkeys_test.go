@@ -0,0 +1,231 @@
+package passkeys
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kostya-zero/blogger/dbtest"
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/passkeys/softauthn"
+)
+
+const origin = "https://blogger.test"
+
+func newTestStore(t *testing.T) (*Store, *models.User) {
+	t.Helper()
+
+	db := dbtest.Open(t)
+	store, err := NewStore(db, "blogger.test", "Blogger", []string{origin})
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+
+	user := models.User{Username: "reader", Email: "reader@example.com", CreatedAt: time.Now()}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	return store, &user
+}
+
+// register adds a passkey held by the authenticator to the user.
+func register(t *testing.T, store *Store, user *models.User, auth *softauthn.Authenticator) *models.Passkey {
+	t.Helper()
+
+	options, handle, err := store.BeginRegistration(user)
+	if err != nil {
+		t.Fatalf("begin registration: %v", err)
+	}
+
+	response, err := auth.Register(options)
+	if err != nil {
+		t.Fatalf("authenticator register: %v", err)
+	}
+
+	passkey, err := store.FinishRegistration(user, handle, "laptop", response)
+	if err != nil {
+		t.Fatalf("finish registration: %v", err)
+	}
+	return passkey
+}
+
+// login runs a login ceremony, for the user or discoverable when user is nil.
+func login(t *testing.T, store *Store, user *models.User, auth *softauthn.Authenticator) (*Assertion, error) {
+	t.Helper()
+
+	options, handle, err := store.BeginLogin(user)
+	if err != nil {
+		t.Fatalf("begin login: %v", err)
+	}
+
+	response, err := auth.Login(options)
+	if err != nil {
+		t.Fatalf("authenticator login: %v", err)
+	}
+
+	ceremony := CeremonyAssert
+	if user == nil {
+		ceremony = CeremonyLogin
+	}
+	return store.FinishLogin(handle, ceremony, response)
+}
+
+func TestRegistration(t *testing.T) {
+	store, user := newTestStore(t)
+	auth := softauthn.New(origin)
+
+	passkey := register(t, store, user, auth)
+	if passkey.UserID != user.ID || len(passkey.CredentialID) == 0 {
+		t.Fatalf("unexpected passkey %+v", passkey)
+	}
+
+	var stored models.User
+	store.DB.First(&stored, user.ID)
+	if len(stored.WebAuthnID) == 0 {
+		t.Error("user handle not saved")
+	}
+
+	if count, _ := store.Count(user.ID); count != 1 {
+		t.Errorf("count %d, want 1", count)
+	}
+}
+
+func TestRegistrationCeremonyIsSingleUse(t *testing.T) {
+	store, user := newTestStore(t)
+	auth := softauthn.New(origin)
+
+	options, handle, err := store.BeginRegistration(user)
+	if err != nil {
+		t.Fatalf("begin registration: %v", err)
+	}
+	response, _ := auth.Register(options)
+
+	if _, err := store.FinishRegistration(user, handle, "laptop", response); err != nil {
+		t.Fatalf("finish registration: %v", err)
+	}
+	if _, err := store.FinishRegistration(user, handle, "laptop", response); !errors.Is(err, ErrCeremonyNotFound) {
+		t.Errorf("replayed registration: %v, want ErrCeremonyNotFound", err)
+	}
+}
+
+func TestRegistrationFromOtherOrigin(t *testing.T) {
+	store, user := newTestStore(t)
+
+	options, handle, err := store.BeginRegistration(user)
+	if err != nil {
+		t.Fatalf("begin registration: %v", err)
+	}
+	response, _ := softauthn.New("https://evil.test").Register(options)
+
+	if _, err := store.FinishRegistration(user, handle, "laptop", response); err == nil {
+		t.Error("registration from another origin accepted")
+	}
+}
+
+func TestDiscoverableLogin(t *testing.T) {
+	store, user := newTestStore(t)
+	auth := softauthn.New(origin)
+	passkey := register(t, store, user, auth)
+
+	assertion, err := login(t, store, nil, auth)
+	if err != nil {
+		t.Fatalf("finish login: %v", err)
+	}
+
+	if assertion.User.ID != user.ID || assertion.Passkey.ID != passkey.ID {
+		t.Errorf("signed in user %d with passkey %d, want %d and %d", assertion.User.ID, assertion.Passkey.ID, user.ID, passkey.ID)
+	}
+	if !assertion.UserVerified {
+		t.Error("user verification not reported")
+	}
+	if assertion.Passkey.LastUsedAt == nil {
+		t.Error("last use not recorded")
+	}
+}
+
+func TestDiscoverableLoginWithUnknownPasskey(t *testing.T) {
+	store, user := newTestStore(t)
+	register(t, store, user, softauthn.New(origin))
+
+	// A passkey for the same site that was never registered here.
+	other, _ := newTestStore(t)
+	stranger := softauthn.New(origin)
+	register(t, other, &models.User{ID: user.ID, Username: "reader"}, stranger)
+
+	if _, err := login(t, store, nil, stranger); err == nil {
+		t.Error("unknown passkey accepted")
+	}
+}
+
+func TestLoginOfKnownUser(t *testing.T) {
+	store, user := newTestStore(t)
+	auth := softauthn.New(origin)
+	auth.SkipUserVerification = true
+	passkey := register(t, store, user, auth)
+
+	options, _, err := store.BeginLogin(user)
+	if err != nil {
+		t.Fatalf("begin login: %v", err)
+	}
+	if len(options.Response.AllowedCredentials) != 1 {
+		t.Fatalf("%d allowed credentials, want 1", len(options.Response.AllowedCredentials))
+	}
+
+	assertion, err := login(t, store, user, auth)
+	if err != nil {
+		t.Fatalf("finish login: %v", err)
+	}
+	if assertion.Passkey.ID != passkey.ID || assertion.UserVerified {
+		t.Errorf("unexpected assertion %+v", assertion)
+	}
+}
+
+func TestLoginOfUserWithoutPasskeys(t *testing.T) {
+	store, user := newTestStore(t)
+
+	if _, _, err := store.BeginLogin(user); !errors.Is(err, ErrNoPasskeys) {
+		t.Errorf("begin login: %v, want ErrNoPasskeys", err)
+	}
+}
+
+func TestLoginCeremonyKindMustMatch(t *testing.T) {
+	store, user := newTestStore(t)
+	auth := softauthn.New(origin)
+	register(t, store, user, auth)
+
+	options, handle, err := store.BeginLogin(user)
+	if err != nil {
+		t.Fatalf("begin login: %v", err)
+	}
+	response, _ := auth.Login(options)
+
+	if _, err := store.FinishLogin(handle, CeremonyLogin, response); !errors.Is(err, ErrCeremonyNotFound) {
+		t.Errorf("finish as discoverable login: %v, want ErrCeremonyNotFound", err)
+	}
+}
+
+func TestSignCount(t *testing.T) {
+	store, user := newTestStore(t)
+	auth := softauthn.New(origin)
+	register(t, store, user, auth)
+
+	if _, err := login(t, store, user, auth); err != nil {
+		t.Fatalf("first login: %v", err)
+	}
+	clone := auth.Clone()
+
+	// The counter only goes up while a single authenticator is used.
+	for range 2 {
+		if _, err := login(t, store, user, auth); err != nil {
+			t.Fatalf("login: %v", err)
+		}
+	}
+
+	if _, err := login(t, store, user, clone); !errors.Is(err, ErrCloned) {
+		t.Errorf("login with cloned authenticator: %v, want ErrCloned", err)
+	}
+	if _, err := login(t, store, nil, clone); !errors.Is(err, ErrCloned) {
+		t.Errorf("discoverable login with cloned authenticator: %v, want ErrCloned", err)
+	}
+}
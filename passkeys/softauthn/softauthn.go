@@ -0,0 +1,241 @@
+// Package softauthn is a software WebAuthn authenticator. It answers the
+// options produced by the passkeys package the way a browser and a platform
+// authenticator would, so ceremonies can be exercised without hardware.
+package softauthn
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+)
+
+var ErrNoCredential = errors.New("no matching credential")
+
+// Authenticator data flags (WebAuthn §6.1).
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+// COSE key parameters of an ES256 public key (RFC 9053).
+const (
+	coseKeyTypeEC2 = 2
+	coseAlgES256   = -7
+	coseCurveP256  = 1
+)
+
+const credentialIDBytes = 32
+
+type credential struct {
+	id         []byte
+	rpID       string
+	userHandle []byte
+	key        *ecdsa.PrivateKey
+	counter    uint32
+}
+
+// Authenticator holds ES256 credentials in memory. Origin is reported in the
+// client data of every response.
+type Authenticator struct {
+	Origin string
+
+	// SkipUserVerification makes responses claim user presence only.
+	SkipUserVerification bool
+
+	mu          sync.Mutex
+	credentials []*credential
+}
+
+func New(origin string) *Authenticator {
+	return &Authenticator{Origin: origin}
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (a *Authenticator) clientData(kind string, challenge []byte) []byte {
+	data, _ := json.Marshal(map[string]any{
+		"type":        kind,
+		"challenge":   encode(challenge),
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+	return data
+}
+
+func (a *Authenticator) flags() byte {
+	if a.SkipUserVerification {
+		return flagUserPresent
+	}
+	return flagUserPresent | flagUserVerified
+}
+
+func authData(rpID string, flags byte, counter uint32, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+
+	var buf bytes.Buffer
+	buf.Write(rpIDHash[:])
+	buf.WriteByte(flags)
+	binary.Write(&buf, binary.BigEndian, counter)
+	buf.Write(attested)
+	return buf.Bytes()
+}
+
+func userHandle(id any) ([]byte, error) {
+	switch v := id.(type) {
+	case protocol.URLEncodedBase64:
+		return v, nil
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+	return nil, errors.New("unsupported user id type")
+}
+
+// Register creates a credential for the creation options and returns the
+// JSON the browser would post back to the relying party.
+func (a *Authenticator) Register(options *protocol.CredentialCreation) ([]byte, error) {
+	opts := options.Response
+
+	handle, err := userHandle(opts.User.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	cred := &credential{
+		id:         make([]byte, credentialIDBytes),
+		rpID:       opts.RelyingParty.ID,
+		userHandle: handle,
+		key:        key,
+	}
+	if _, err := rand.Read(cred.id); err != nil {
+		return nil, err
+	}
+
+	coseKey, err := webauthncbor.Marshal(map[int]any{
+		1:  coseKeyTypeEC2,
+		3:  coseAlgES256,
+		-1: coseCurveP256,
+		-2: key.PublicKey.X.FillBytes(make([]byte, 32)),
+		-3: key.PublicKey.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var attested bytes.Buffer
+	attested.Write(make([]byte, 16)) // AAGUID
+	binary.Write(&attested, binary.BigEndian, uint16(len(cred.id)))
+	attested.Write(cred.id)
+	attested.Write(coseKey)
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": authData(cred.rpID, a.flags()|flagAttestedData, 0, attested.Bytes()),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	a.credentials = append(a.credentials, cred)
+	a.mu.Unlock()
+
+	return json.Marshal(map[string]any{
+		"id":                      encode(cred.id),
+		"rawId":                   encode(cred.id),
+		"type":                    "public-key",
+		"authenticatorAttachment": "platform",
+		"response": map[string]any{
+			"clientDataJSON":    encode(a.clientData("webauthn.create", opts.Challenge)),
+			"attestationObject": encode(attestation),
+			"transports":        []string{"internal"},
+		},
+	})
+}
+
+// Login signs the request options with a matching credential and returns
+// the JSON the browser would post back to the relying party.
+func (a *Authenticator) Login(options *protocol.CredentialAssertion) ([]byte, error) {
+	opts := options.Response
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var cred *credential
+	for _, c := range a.credentials {
+		if c.rpID != opts.RelyingPartyID {
+			continue
+		}
+		if len(opts.AllowedCredentials) == 0 {
+			cred = c
+			break
+		}
+		for _, allowed := range opts.AllowedCredentials {
+			if bytes.Equal(allowed.CredentialID, c.id) {
+				cred = c
+			}
+		}
+	}
+	if cred == nil {
+		return nil, ErrNoCredential
+	}
+
+	cred.counter++
+	auth := authData(cred.rpID, a.flags(), cred.counter, nil)
+	clientData := a.clientData("webauthn.get", opts.Challenge)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, auth...), clientDataHash[:]...))
+
+	signature, err := ecdsa.SignASN1(rand.Reader, cred.key, digest[:])
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(map[string]any{
+		"id":                      encode(cred.id),
+		"rawId":                   encode(cred.id),
+		"type":                    "public-key",
+		"authenticatorAttachment": "platform",
+		"response": map[string]any{
+			"clientDataJSON":    encode(clientData),
+			"authenticatorData": encode(auth),
+			"signature":         encode(signature),
+			"userHandle":        encode(cred.userHandle),
+		},
+	})
+}
+
+// Clone returns an authenticator holding copies of the credentials and their
+// signature counters, as an extracted private key would be. Using both then
+// lets the relying party notice the counter going backwards.
+func (a *Authenticator) Clone() *Authenticator {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	clone := &Authenticator{Origin: a.Origin, SkipUserVerification: a.SkipUserVerification}
+	for _, c := range a.credentials {
+		copied := *c
+		clone.credentials = append(clone.credentials, &copied)
+	}
+	return clone
+}
@@ -0,0 +1,40 @@
+package password
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Bcrypt verifies hashes created before the switch to Argon2id. It is not
+// meant as the default: bcrypt ignores everything past 72 bytes.
+type Bcrypt struct {
+	Cost int
+}
+
+func (b *Bcrypt) ID() []string {
+	return []string{"2a", "2b", "2y"}
+}
+
+func (b *Bcrypt) Hash(password string) (string, error) {
+	cost := b.Cost
+	if cost == 0 {
+		cost = bcrypt.DefaultCost
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	return string(hash), err
+}
+
+func (b *Bcrypt) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (b *Bcrypt) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || (b.Cost != 0 && cost != b.Cost)
+}
@@ -0,0 +1,92 @@
+// Package password hashes and verifies user passwords. Hashes are stored as
+// PHC strings ("$<id>$...") so that the algorithm can be changed later and
+// old hashes upgraded on the next successful login.
+package password
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+)
+
+var ErrUnknownAlgorithm = errors.New("unknown password hash algorithm")
+
+type Hasher interface {
+	// ID returns the PHC identifiers this hasher produces and understands.
+	ID() []string
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash reports whether encoded was produced with parameters other
+	// than the hasher's current ones.
+	NeedsRehash(encoded string) bool
+}
+
+var (
+	// Default hashes new passwords.
+	Default Hasher = NewArgon2id(DefaultArgon2idParams)
+
+	// legacy hashers can only verify; matching hashes are upgraded to
+	// Default.
+	legacy = []Hasher{&Bcrypt{}}
+
+	dummyOnce sync.Once
+	dummyHash string
+)
+
+// Configure replaces the default hasher. Call it before serving requests.
+func Configure(h Hasher) {
+	Default = h
+}
+
+func Hash(password string) (string, error) {
+	return Default.Hash(password)
+}
+
+// Verify checks the password against an encoded hash. rehash is true when the
+// password is correct but the hash should be replaced with Hash(password).
+func Verify(password, encoded string) (ok bool, rehash bool, err error) {
+	if encoded == "" {
+		return false, false, nil
+	}
+
+	hasher := find(encoded)
+	if hasher == nil {
+		return false, false, ErrUnknownAlgorithm
+	}
+
+	ok, err = hasher.Verify(password, encoded)
+	if err != nil || !ok {
+		return false, false, err
+	}
+
+	return true, hasher != Default || Default.NeedsRehash(encoded), nil
+}
+
+// VerifyDummy spends as much time as verifying a real password, so that
+// unknown accounts can't be told apart by response time.
+func VerifyDummy(password string) {
+	dummyOnce.Do(func() {
+		dummyHash, _ = Default.Hash("blogger dummy password")
+	})
+	Default.Verify(password, dummyHash)
+}
+
+func find(encoded string) Hasher {
+	id := identifier(encoded)
+	for _, h := range append([]Hasher{Default}, legacy...) {
+		if slices.Contains(h.ID(), id) {
+			return h
+		}
+	}
+	return nil
+}
+
+// identifier returns the algorithm id of a PHC string, e.g. "argon2id".
+func identifier(encoded string) string {
+	if !strings.HasPrefix(encoded, "$") {
+		return ""
+	}
+	id, _, _ := strings.Cut(encoded[1:], "$")
+	return id
+}
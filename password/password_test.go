@@ -0,0 +1,134 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testParams keep the tests fast; the encoding doesn't depend on them.
+var testParams = Argon2idParams{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+// useDefault replaces Default for the duration of the test.
+func useDefault(t *testing.T, h Hasher) {
+	t.Helper()
+
+	previous := Default
+	Default = h
+	t.Cleanup(func() { Default = previous })
+}
+
+func TestArgon2idRoundTrip(t *testing.T) {
+	a := NewArgon2id(testParams)
+
+	encoded, err := a.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("hash = %q, want a PHC argon2id string", encoded)
+	}
+
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if params != testParams || len(salt) != 16 || len(key) != 32 {
+		t.Fatalf("decoded %+v with %d byte salt and %d byte key, want %+v", params, len(salt), len(key), testParams)
+	}
+
+	if ok, err := a.Verify("correct horse", encoded); !ok || err != nil {
+		t.Fatalf("verify correct password = %v, %v", ok, err)
+	}
+	if ok, err := a.Verify("battery staple", encoded); ok || err != nil {
+		t.Fatalf("verify wrong password = %v, %v", ok, err)
+	}
+	if a.NeedsRehash(encoded) {
+		t.Fatal("fresh hash needs rehash")
+	}
+}
+
+func TestArgon2idMalformed(t *testing.T) {
+	a := NewArgon2id(testParams)
+	valid, err := a.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	parts := strings.Split(valid, "$")
+
+	for _, tt := range []struct {
+		name    string
+		encoded string
+	}{
+		{"empty", ""},
+		{"too few fields", "$argon2id$v=19$m=1024,t=1,p=1$" + parts[4]},
+		{"too many fields", valid + "$extra"},
+		{"other algorithm", strings.Replace(valid, "argon2id", "argon2i", 1)},
+		{"other version", strings.Replace(valid, "v=19", "v=16", 1)},
+		{"bad version", strings.Replace(valid, "v=19", "v=x", 1)},
+		{"bad parameters", strings.Replace(valid, "m=1024,t=1,p=1", "m=1024,t=1", 1)},
+		{"bad salt", strings.Replace(valid, parts[4], "!!!", 1)},
+		{"bad key", strings.Replace(valid, parts[5], "!!!", 1)},
+		{"empty key", strings.TrimSuffix(valid, parts[5])},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, _, err := decodeArgon2id(tt.encoded); !errors.Is(err, errInvalidArgon2idHash) {
+				t.Fatalf("decode = %v, want %v", err, errInvalidArgon2idHash)
+			}
+			if ok, err := a.Verify("correct horse", tt.encoded); ok || err == nil {
+				t.Fatalf("verify = %v, %v, want an error", ok, err)
+			}
+			if !a.NeedsRehash(tt.encoded) {
+				t.Fatal("malformed hash doesn't need rehash")
+			}
+		})
+	}
+}
+
+func TestArgon2idWeakerParamsNeedRehash(t *testing.T) {
+	stronger := testParams
+	stronger.Iterations = 2
+	useDefault(t, NewArgon2id(stronger))
+
+	weak, err := NewArgon2id(testParams).Hash("correct horse")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+
+	ok, rehash, err := Verify("correct horse", weak)
+	if !ok || !rehash || err != nil {
+		t.Fatalf("Verify = %v, %v, %v, want a correct password that needs rehash", ok, rehash, err)
+	}
+}
+
+func TestVerifyBcrypt(t *testing.T) {
+	useDefault(t, NewArgon2id(testParams))
+
+	legacyHash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+
+	ok, rehash, err := Verify("correct horse", string(legacyHash))
+	if !ok || !rehash || err != nil {
+		t.Fatalf("Verify = %v, %v, %v, want a correct password that needs rehash", ok, rehash, err)
+	}
+
+	ok, rehash, err = Verify("battery staple", string(legacyHash))
+	if ok || rehash || err != nil {
+		t.Fatalf("Verify wrong password = %v, %v, %v", ok, rehash, err)
+	}
+}
+
+func TestVerifyUnknown(t *testing.T) {
+	useDefault(t, NewArgon2id(testParams))
+
+	if ok, _, err := Verify("correct horse", "$md5$abc"); ok || !errors.Is(err, ErrUnknownAlgorithm) {
+		t.Fatalf("Verify = %v, %v, want %v", ok, err, ErrUnknownAlgorithm)
+	}
+	if ok, _, err := Verify("correct horse", ""); ok || err != nil {
+		t.Fatalf("Verify without hash = %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,121 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	ErrContainsIdentity = errors.New("Password must not contain your username or email")
+	ErrTooWeak          = errors.New("Password is too easy to guess, use a longer or more varied one")
+	ErrBreached         = errors.New("Password appeared in a data breach, choose a different one")
+)
+
+// Policy decides which new passwords are acceptable.
+type Policy struct {
+	MinLength int
+	MaxLength int
+
+	// MinEntropy is the minimum estimated strength in bits.
+	MinEntropy float64
+
+	// Breached, when set, rejects passwords found in known breaches.
+	Breached *BreachedList
+}
+
+var DefaultPolicy = &Policy{MinLength: 8, MaxLength: 128, MinEntropy: 50}
+
+// ConfigurePolicy replaces the policy used by Check. Call it before serving
+// requests.
+func ConfigurePolicy(p *Policy) {
+	DefaultPolicy = p
+}
+
+// Check validates a new password for the account with the default policy.
+func Check(password, username, email string) error {
+	return DefaultPolicy.Check(password, username, email)
+}
+
+// Check returns an error describing the first rule the password breaks. The
+// error messages are meant to be shown to the user.
+func (p *Policy) Check(password, username, email string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return fmt.Errorf("Password must be at least %d characters long", p.MinLength)
+	}
+	if length > p.MaxLength {
+		return fmt.Errorf("Password must be at most %d characters long", p.MaxLength)
+	}
+
+	lower := strings.ToLower(password)
+	localPart, _, _ := strings.Cut(strings.ToLower(email), "@")
+	for _, identity := range []string{strings.ToLower(username), localPart} {
+		if len(identity) >= 3 && strings.Contains(lower, identity) {
+			return ErrContainsIdentity
+		}
+	}
+
+	if Entropy(password) < p.MinEntropy {
+		return ErrTooWeak
+	}
+
+	if p.Breached != nil && p.Breached.Contains(password) {
+		return ErrBreached
+	}
+
+	return nil
+}
+
+// Entropy estimates the strength of the password in bits from the character
+// classes it uses. Characters repeating or continuing a sequence from the
+// previous one ("aaa", "abc", "321") add almost nothing.
+func Entropy(password string) float64 {
+	var hasLower, hasUpper, hasDigit, hasSymbol, hasOther bool
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			hasLower = true
+		case r >= 'A' && r <= 'Z':
+			hasUpper = true
+		case r >= '0' && r <= '9':
+			hasDigit = true
+		case r < unicode.MaxASCII && unicode.IsPrint(r):
+			hasSymbol = true
+		default:
+			hasOther = true
+		}
+	}
+
+	pool := 0
+	for _, class := range []struct {
+		present bool
+		size    int
+	}{
+		{hasLower, 26}, {hasUpper, 26}, {hasDigit, 10}, {hasSymbol, 33}, {hasOther, 100},
+	} {
+		if class.present {
+			pool += class.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+
+	bitsPerChar := math.Log2(float64(pool))
+	bits := 0.0
+	var prev rune = -1
+	for _, r := range password {
+		if prev >= 0 && (r == prev || r == prev+1 || r == prev-1) {
+			bits += 1
+		} else {
+			bits += bitsPerChar
+		}
+		prev = r
+	}
+
+	return bits
+}
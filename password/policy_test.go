@@ -0,0 +1,135 @@
+package password
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPolicyLength(t *testing.T) {
+	p := &Policy{MinLength: 8, MaxLength: 128}
+
+	for _, tt := range []struct {
+		name     string
+		password string
+		ok       bool
+	}{
+		{"one short", strings.Repeat("x", 7), false},
+		{"minimum", strings.Repeat("x", 8), true},
+		{"minimum in runes", strings.Repeat("é", 8), true},
+		{"maximum", strings.Repeat("x", 128), true},
+		{"one long", strings.Repeat("x", 129), false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Check(tt.password, "", "")
+			if (err == nil) != tt.ok {
+				t.Fatalf("Check = %v, want ok %v", err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestPolicyIdentity(t *testing.T) {
+	p := &Policy{MinLength: 8, MaxLength: 128}
+
+	for _, password := range []string{"my-Alice-password", "xxjsmith99xx"} {
+		if err := p.Check(password, "alice", "jsmith@example.com"); !errors.Is(err, ErrContainsIdentity) {
+			t.Errorf("Check(%q) = %v, want %v", password, err, ErrContainsIdentity)
+		}
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	p := &Policy{MinLength: 8, MaxLength: 128, MinEntropy: 50}
+
+	// Lowercase letters are worth log2(26) ≈ 4.7 bits each, so 50 bits
+	// take 11 of them.
+	for _, tt := range []struct {
+		name     string
+		password string
+		ok       bool
+	}{
+		{"ten lowercase", "qwmzpxkrvt", false},
+		{"eleven lowercase", "qwmzpxkrvtj", true},
+		{"mixed classes", "Qw7$zpXk", true},
+		{"sequence", "abcdefghijklmnopq", false},
+		{"repetition", "aaaaaaaaaaaaaaaaaaaaaaa", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Check(tt.password, "", "")
+			if tt.ok && err != nil {
+				t.Fatalf("Check = %v (%.1f bits), want ok", err, Entropy(tt.password))
+			}
+			if !tt.ok && !errors.Is(err, ErrTooWeak) {
+				t.Fatalf("Check = %v (%.1f bits), want %v", err, Entropy(tt.password), ErrTooWeak)
+			}
+		})
+	}
+
+	if bits := Entropy(""); bits != 0 {
+		t.Fatalf("Entropy of empty password = %f, want 0", bits)
+	}
+}
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func writeList(t *testing.T, lines ...string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		t.Fatalf("write list: %v", err)
+	}
+	return path
+}
+
+func TestLoadBreachedList(t *testing.T) {
+	path := writeList(t,
+		strings.ToUpper(sha1Hex("Tr0ub4dor&3xyzQ")),
+		"",
+		"   ",
+		sha1Hex("correct horse battery staple")+":42",
+		"  "+strings.ToUpper(sha1Hex("hunter2hunter2"))+":7  ",
+	)
+
+	list, err := LoadBreachedList(path)
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if list.Len() != 3 {
+		t.Fatalf("Len = %d, want 3", list.Len())
+	}
+
+	for _, password := range []string{"Tr0ub4dor&3xyzQ", "correct horse battery staple", "hunter2hunter2"} {
+		if !list.Contains(password) {
+			t.Errorf("Contains(%q) = false, want true", password)
+		}
+	}
+	if list.Contains("Hunter2hunter2") {
+		t.Error("Contains matched a password differing in case")
+	}
+
+	p := &Policy{MinLength: 8, MaxLength: 128, MinEntropy: 50, Breached: list}
+	if err := p.Check("Tr0ub4dor&3xyzQ", "", ""); !errors.Is(err, ErrBreached) {
+		t.Fatalf("Check of breached password = %v, want %v", err, ErrBreached)
+	}
+	if err := p.Check("Tr0ub4dor&3xyzR", "", ""); err != nil {
+		t.Fatalf("Check of other password = %v", err)
+	}
+}
+
+func TestLoadBreachedListMalformed(t *testing.T) {
+	path := writeList(t, sha1Hex("one"), "not a hash")
+
+	_, err := LoadBreachedList(path)
+	if err == nil || !strings.HasSuffix(err.Error(), ":2: not a SHA-1 hash") {
+		t.Fatalf("load = %v, want an error on line 2", err)
+	}
+}
@@ -0,0 +1,151 @@
+// Package pat implements personal access tokens: opaque, scoped tokens that
+// authenticate scripts without a password login.
+package pat
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/helpers"
+	"github.com/kostya-zero/blogger/jwt"
+	"github.com/kostya-zero/blogger/models"
+	"gorm.io/gorm"
+)
+
+// TokenPrefix starts every personal access token, which tells them apart from
+// JWT access tokens and makes leaked tokens easy to scan for.
+const TokenPrefix = "blg_pat_"
+
+const (
+	ScopePostsWrite    = "posts:write"
+	ScopeLikesWrite    = "likes:write"
+	ScopeSettingsWrite = "settings:write"
+)
+
+// Scopes lists every scope a token can be granted.
+var Scopes = []string{ScopePostsWrite, ScopeLikesWrite, ScopeSettingsWrite}
+
+var (
+	ErrInvalidToken = errors.New("invalid personal access token")
+	ErrUnknownScope = errors.New("unknown scope")
+)
+
+// usedInterval limits how often LastUsedAt is written.
+const usedInterval = time.Minute
+
+type Store struct {
+	DB *gorm.DB
+}
+
+func NewStore(db *gorm.DB) *Store {
+	return &Store{DB: db}
+}
+
+// Create issues a new token. The raw token is returned only here; just its
+// hash is stored.
+func (s *Store) Create(userID uint, name string, scopes []string, expiresAt *time.Time) (string, *models.PersonalAccessToken, error) {
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return "", nil, ErrUnknownScope
+		}
+	}
+
+	random, err := helpers.RandomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	raw := TokenPrefix + random
+	token := models.PersonalAccessToken{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    raw[:len(TokenPrefix)+6],
+		TokenHash: helpers.HashToken(raw),
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	if err := s.DB.Create(&token).Error; err != nil {
+		return "", nil, err
+	}
+
+	return raw, &token, nil
+}
+
+// List returns tokens of the user that are not revoked.
+func (s *Store) List(userID uint) ([]models.PersonalAccessToken, error) {
+	var tokens []models.PersonalAccessToken
+	err := s.DB.Where("user_id = ? AND revoked_at IS NULL", userID).Order("created_at DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+func (s *Store) Revoke(userID, tokenID uint) error {
+	result := s.DB.Model(&models.PersonalAccessToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", tokenID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// Authenticate resolves a raw token to claims carrying its scopes.
+func (s *Store) Authenticate(raw string) (*jwt.TokenClaims, error) {
+	var token models.PersonalAccessToken
+	if err := s.DB.Where("token_hash = ?", helpers.HashToken(raw)).First(&token).Error; err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	now := time.Now()
+	if token.RevokedAt != nil || (token.ExpiresAt != nil && now.After(*token.ExpiresAt)) {
+		return nil, ErrInvalidToken
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) > usedInterval {
+		s.DB.Model(&token).Update("last_used_at", now)
+	}
+
+	return &jwt.TokenClaims{
+		UserID:        token.UserID,
+		PersonalToken: true,
+		Scopes:        strings.Fields(token.Scopes),
+	}, nil
+}
+
+// Middleware authenticates personal access tokens sent as bearer tokens and
+// hands every other request to next, usually jwt.JwtMiddleware. The checks
+// run on the claims of every valid token.
+func Middleware(store *Store, next fiber.Handler, checks ...jwt.Check) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		token := jwt.TokenFromRequest(c)
+		if !strings.HasPrefix(token, TokenPrefix) {
+			return next(c)
+		}
+
+		claims, err := store.Authenticate(token)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid or expired access token.",
+			})
+		}
+
+		for _, check := range checks {
+			if err := check(claims); err != nil {
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+					"error": "Invalid or expired access token.",
+				})
+			}
+		}
+
+		c.Locals("user", claims)
+
+		return c.Next()
+	}
+}
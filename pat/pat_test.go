@@ -0,0 +1,145 @@
+package pat
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/dbtest"
+	"github.com/kostya-zero/blogger/jwt"
+	"github.com/kostya-zero/blogger/models"
+)
+
+type patTest struct {
+	store *Store
+	app   *fiber.App
+	user  *models.User
+}
+
+// newPATTest wires the token routes the way the server does, with handlers
+// that only report success.
+func newPATTest(t *testing.T) *patTest {
+	t.Helper()
+
+	db := dbtest.Open(t)
+	user := models.User{Username: "reader", Email: "reader@example.com", CreatedAt: time.Now()}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	store := NewStore(db)
+	// Requests without a personal access token are not under test.
+	jwtMiddleware := func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusUnauthorized)
+	}
+	authRequired := Middleware(store, jwtMiddleware)
+	ok := func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	}
+
+	app := fiber.New()
+	app.Post("/posts/create", authRequired, jwt.RequireScope(ScopePostsWrite), ok)
+	app.Post("/posts/like", authRequired, jwt.RequireScope(ScopeLikesWrite), ok)
+	app.Post("/settings/update-password", authRequired, jwt.RequireSession(), ok)
+	app.Post("/tokens/create", authRequired, jwt.RequireSession(), ok)
+	app.Get("/tokens/list", authRequired, jwt.RequireSession(), ok)
+
+	return &patTest{store: store, app: app, user: &user}
+}
+
+func (pt *patTest) create(t *testing.T, scopes []string, expiresAt *time.Time) (string, *models.PersonalAccessToken) {
+	t.Helper()
+
+	raw, token, err := pt.store.Create(pt.user.ID, "script", scopes, expiresAt)
+	if err != nil {
+		t.Fatalf("create token: %v", err)
+	}
+	return raw, token
+}
+
+func (pt *patTest) status(t *testing.T, method, path, token string) int {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+	resp, err := pt.app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	return resp.StatusCode
+}
+
+func TestScopes(t *testing.T) {
+	pt := newPATTest(t)
+	likes, _ := pt.create(t, []string{ScopeLikesWrite}, nil)
+	posts, _ := pt.create(t, []string{ScopePostsWrite}, nil)
+
+	for _, tt := range []struct {
+		path, token string
+		want        int
+	}{
+		{"/posts/create", likes, http.StatusForbidden},
+		{"/posts/create", posts, http.StatusOK},
+		{"/posts/like", likes, http.StatusOK},
+		{"/posts/like", posts, http.StatusForbidden},
+	} {
+		if got := pt.status(t, http.MethodPost, tt.path, tt.token); got != tt.want {
+			t.Errorf("POST %s = %d, want %d", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSessionOnlyRoutes(t *testing.T) {
+	pt := newPATTest(t)
+	raw, _ := pt.create(t, Scopes, nil)
+
+	for _, route := range []struct{ method, path string }{
+		{http.MethodPost, "/tokens/create"},
+		{http.MethodGet, "/tokens/list"},
+		{http.MethodPost, "/settings/update-password"},
+	} {
+		if got := pt.status(t, route.method, route.path, raw); got != http.StatusForbidden {
+			t.Errorf("%s %s = %d, want %d", route.method, route.path, got, http.StatusForbidden)
+		}
+	}
+}
+
+func TestRevokedAndExpiredTokens(t *testing.T) {
+	pt := newPATTest(t)
+
+	revoked, token := pt.create(t, []string{ScopePostsWrite}, nil)
+	if err := pt.store.Revoke(pt.user.ID, token.ID); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+
+	past := time.Now().Add(-time.Minute)
+	expired, _ := pt.create(t, []string{ScopePostsWrite}, &past)
+
+	for name, raw := range map[string]string{
+		"revoked": revoked,
+		"expired": expired,
+		"unknown": TokenPrefix + "unknown",
+	} {
+		if got := pt.status(t, http.MethodPost, "/posts/create", raw); got != http.StatusUnauthorized {
+			t.Errorf("%s token = %d, want %d", name, got, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestCreateRejectsUnknownScopes(t *testing.T) {
+	pt := newPATTest(t)
+
+	_, _, err := pt.store.Create(pt.user.ID, "script", []string{ScopePostsWrite, "admin"}, nil)
+	if !errors.Is(err, ErrUnknownScope) {
+		t.Fatalf("create = %v, want %v", err, ErrUnknownScope)
+	}
+
+	var count int64
+	pt.store.DB.Model(&models.PersonalAccessToken{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("%d tokens stored", count)
+	}
+}
@@ -0,0 +1,145 @@
+// Package publishing defines the states of a post and publishes scheduled
+// posts when their time comes.
+package publishing
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kostya-zero/blogger/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Post states. Drafts and scheduled posts are seen by their author only;
+// archived posts can still be opened but are no longer listed.
+const (
+	StatusDraft     = "draft"
+	StatusScheduled = "scheduled"
+	StatusPublished = "published"
+	StatusArchived  = "archived"
+)
+
+// Public lists the states in which anyone can open a post.
+var Public = []string{StatusPublished, StatusArchived}
+
+var (
+	ErrUnknownStatus     = errors.New("unknown post status")
+	ErrPublishAtRequired = errors.New("scheduled posts need a publish time in the future")
+	ErrAlreadyPublished  = errors.New("post was already published")
+	ErrNotPublished      = errors.New("only published posts can be archived")
+)
+
+// Set moves the post to the status, filling in when it goes public. A post
+// that was published once can go back to draft but not be scheduled again,
+// and only published posts can be archived. The caller saves the status,
+// published_at and scheduled_at columns.
+func Set(post *models.Post, status string, publishAt *time.Time, now time.Time) error {
+	switch status {
+	case StatusDraft:
+		post.ScheduledAt = nil
+	case StatusScheduled:
+		if publishAt == nil || !publishAt.After(now) {
+			return ErrPublishAtRequired
+		}
+		if post.PublishedAt != nil {
+			return ErrAlreadyPublished
+		}
+		post.ScheduledAt = publishAt
+	case StatusPublished:
+		if post.PublishedAt == nil {
+			post.PublishedAt = &now
+		}
+		post.ScheduledAt = nil
+	case StatusArchived:
+		if post.Status != StatusPublished && post.Status != StatusArchived {
+			return ErrNotPublished
+		}
+	default:
+		return ErrUnknownStatus
+	}
+
+	post.Status = status
+	return nil
+}
+
+// Backfill dates posts from before publish states, which went public when
+// they were created.
+func Backfill(db *gorm.DB) error {
+	return db.Unscoped().Model(&models.Post{}).
+		Where("status = ? AND published_at IS NULL", StatusPublished).
+		Update("published_at", gorm.Expr("created_at")).Error
+}
+
+// batchSize limits how many posts a single transaction publishes.
+const batchSize = 100
+
+// Scheduler publishes scheduled posts. The schedule lives in the posts table,
+// so nothing is lost on restart, and rows are claimed with SKIP LOCKED, so
+// any number of instances can run it against the same database.
+type Scheduler struct {
+	DB *gorm.DB
+}
+
+func NewScheduler(db *gorm.DB) *Scheduler {
+	return &Scheduler{DB: db}
+}
+
+// Start looks for due posts every interval, starting right away to catch up
+// on posts that came due while no instance was running.
+func (s *Scheduler) Start(interval time.Duration) {
+	go s.publishLoop(interval)
+}
+
+// PublishDue publishes every scheduled post whose time has come and returns
+// how many it published.
+func (s *Scheduler) PublishDue() (int, error) {
+	total := 0
+	for {
+		var due []models.Post
+		err := s.DB.Transaction(func(tx *gorm.DB) error {
+			now := time.Now()
+			err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Select("id", "scheduled_at").
+				Where("status = ? AND scheduled_at <= ?", StatusScheduled, now).
+				Order("scheduled_at").
+				Limit(batchSize).
+				Find(&due).Error
+			if err != nil || len(due) == 0 {
+				return err
+			}
+
+			ids := make([]uint, 0, len(due))
+			for _, post := range due {
+				ids = append(ids, post.ID)
+			}
+
+			return tx.Model(&models.Post{}).Where("id IN ?", ids).Updates(map[string]any{
+				"status":       StatusPublished,
+				"published_at": now,
+				"scheduled_at": nil,
+			}).Error
+		})
+		if err != nil {
+			return total, err
+		}
+
+		total += len(due)
+		if len(due) < batchSize {
+			return total, nil
+		}
+	}
+}
+
+func (s *Scheduler) publishLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.PublishDue(); err != nil {
+			fmt.Printf("Failed to publish scheduled posts: %s\n", err.Error())
+		}
+		<-ticker.C
+	}
+}
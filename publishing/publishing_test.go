@@ -0,0 +1,201 @@
+package publishing
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/kostya-zero/blogger/dbtest"
+	"github.com/kostya-zero/blogger/models"
+)
+
+func TestSet(t *testing.T) {
+	now := time.Date(2026, 1, 2, 12, 0, 0, 0, time.UTC)
+	earlier := now.Add(-24 * time.Hour)
+	later := now.Add(time.Hour)
+
+	for _, tt := range []struct {
+		name        string
+		post        models.Post
+		status      string
+		publishAt   *time.Time
+		err         error
+		publishedAt *time.Time
+		scheduledAt *time.Time
+	}{
+		{
+			name:        "publish draft",
+			post:        models.Post{Status: StatusDraft},
+			status:      StatusPublished,
+			publishedAt: &now,
+		},
+		{
+			name:        "republish keeps first publication",
+			post:        models.Post{Status: StatusDraft, PublishedAt: &earlier},
+			status:      StatusPublished,
+			publishedAt: &earlier,
+		},
+		{
+			name:        "publish scheduled post early",
+			post:        models.Post{Status: StatusScheduled, ScheduledAt: &later},
+			status:      StatusPublished,
+			publishedAt: &now,
+		},
+		{
+			name:        "schedule draft",
+			post:        models.Post{Status: StatusDraft},
+			status:      StatusScheduled,
+			publishAt:   &later,
+			scheduledAt: &later,
+		},
+		{
+			name:   "schedule without time",
+			post:   models.Post{Status: StatusDraft},
+			status: StatusScheduled,
+			err:    ErrPublishAtRequired,
+		},
+		{
+			name:      "schedule in the past",
+			post:      models.Post{Status: StatusDraft},
+			status:    StatusScheduled,
+			publishAt: &earlier,
+			err:       ErrPublishAtRequired,
+		},
+		{
+			name:        "schedule published post",
+			post:        models.Post{Status: StatusDraft, PublishedAt: &earlier},
+			status:      StatusScheduled,
+			publishAt:   &later,
+			err:         ErrAlreadyPublished,
+			publishedAt: &earlier,
+		},
+		{
+			name:   "unschedule",
+			post:   models.Post{Status: StatusScheduled, ScheduledAt: &later},
+			status: StatusDraft,
+		},
+		{
+			name:        "unpublish",
+			post:        models.Post{Status: StatusPublished, PublishedAt: &earlier},
+			status:      StatusDraft,
+			publishedAt: &earlier,
+		},
+		{
+			name:        "archive published post",
+			post:        models.Post{Status: StatusPublished, PublishedAt: &earlier},
+			status:      StatusArchived,
+			publishedAt: &earlier,
+		},
+		{
+			name:   "archive draft",
+			post:   models.Post{Status: StatusDraft},
+			status: StatusArchived,
+			err:    ErrNotPublished,
+		},
+		{
+			name:        "archive scheduled post",
+			post:        models.Post{Status: StatusScheduled, ScheduledAt: &later},
+			status:      StatusArchived,
+			err:         ErrNotPublished,
+			scheduledAt: &later,
+		},
+		{
+			name:   "unknown status",
+			post:   models.Post{Status: StatusDraft},
+			status: "deleted",
+			err:    ErrUnknownStatus,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			post := tt.post
+			before := post.Status
+
+			err := Set(&post, tt.status, tt.publishAt, now)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Set = %v, want %v", err, tt.err)
+			}
+
+			want := tt.status
+			if tt.err != nil {
+				want = before
+			}
+			if post.Status != want {
+				t.Errorf("status = %q, want %q", post.Status, want)
+			}
+			if !sameTime(post.PublishedAt, tt.publishedAt) {
+				t.Errorf("published_at = %v, want %v", post.PublishedAt, tt.publishedAt)
+			}
+			if !sameTime(post.ScheduledAt, tt.scheduledAt) {
+				t.Errorf("scheduled_at = %v, want %v", post.ScheduledAt, tt.scheduledAt)
+			}
+		})
+	}
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func TestPublishDue(t *testing.T) {
+	db := dbtest.Open(t)
+	user := models.User{Username: "reader", Email: "reader@example.com", CreatedAt: time.Now()}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	now := time.Now()
+	create := func(title, status string, scheduledAt *time.Time) uint {
+		t.Helper()
+
+		post := models.Post{UserID: user.ID, Title: title, Description: title, Content: title, CreatedAt: now, Status: status, ScheduledAt: scheduledAt}
+		if err := db.Create(&post).Error; err != nil {
+			t.Fatalf("create post: %v", err)
+		}
+		return post.ID
+	}
+
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+	due := create("due", StatusScheduled, &past)
+	notDue := create("not due", StatusScheduled, &future)
+	draft := create("draft", StatusDraft, nil)
+
+	published, err := NewScheduler(db).PublishDue()
+	if err != nil {
+		t.Fatalf("publish due: %v", err)
+	}
+	if published != 1 {
+		t.Fatalf("published %d posts, want 1", published)
+	}
+
+	load := func(id uint) models.Post {
+		t.Helper()
+
+		var post models.Post
+		if err := db.First(&post, id).Error; err != nil {
+			t.Fatalf("load post: %v", err)
+		}
+		return post
+	}
+
+	post := load(due)
+	if post.Status != StatusPublished || post.ScheduledAt != nil {
+		t.Errorf("due post is %q scheduled at %v, want published and unscheduled", post.Status, post.ScheduledAt)
+	}
+	if post.PublishedAt == nil || post.PublishedAt.Before(now.Add(-time.Second)) {
+		t.Errorf("due post published at %v, want about %v", post.PublishedAt, now)
+	}
+
+	if post := load(notDue); post.Status != StatusScheduled || post.PublishedAt != nil {
+		t.Errorf("post not due is %q published at %v", post.Status, post.PublishedAt)
+	}
+	if post := load(draft); post.Status != StatusDraft || post.PublishedAt != nil {
+		t.Errorf("draft is %q published at %v", post.Status, post.PublishedAt)
+	}
+
+	if published, err := NewScheduler(db).PublishDue(); err != nil || published != 0 {
+		t.Fatalf("second run = %d, %v, want 0", published, err)
+	}
+}
@@ -0,0 +1,51 @@
+package revocation
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps revoked tokens in process memory. It is suitable for a
+// single instance; revocations are lost on restart.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	revoked map[string]time.Time
+}
+
+// NewMemoryStore creates a store that evicts expired entries every interval.
+func NewMemoryStore(interval time.Duration) *MemoryStore {
+	s := &MemoryStore{revoked: make(map[string]time.Time)}
+	go s.evictLoop(interval)
+	return s
+}
+
+func (s *MemoryStore) Revoke(jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.revoked[jti] = expiresAt
+	return nil
+}
+
+func (s *MemoryStore) IsRevoked(jti string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	expiresAt, ok := s.revoked[jti]
+	return ok && time.Now().Before(expiresAt), nil
+}
+
+func (s *MemoryStore) evictLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		s.mu.Lock()
+		for jti, expiresAt := range s.revoked {
+			if now.After(expiresAt) {
+				delete(s.revoked, jti)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,47 @@
+package revocation
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kostya-zero/blogger/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PostgresStore keeps revoked tokens in the revoked_tokens table so that every
+// instance sharing the database sees them.
+type PostgresStore struct {
+	DB *gorm.DB
+}
+
+// NewPostgresStore creates a store that deletes expired rows every interval.
+func NewPostgresStore(db *gorm.DB, interval time.Duration) *PostgresStore {
+	s := &PostgresStore{DB: db}
+	go s.cleanupLoop(interval)
+	return s
+}
+
+func (s *PostgresStore) Revoke(jti string, expiresAt time.Time) error {
+	return s.DB.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.RevokedToken{JTI: jti, ExpiresAt: expiresAt}).Error
+}
+
+func (s *PostgresStore) IsRevoked(jti string) (bool, error) {
+	var count int64
+	err := s.DB.Model(&models.RevokedToken{}).
+		Where("jti = ? AND expires_at > ?", jti, time.Now()).
+		Count(&count).Error
+	return count > 0, err
+}
+
+func (s *PostgresStore) cleanupLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := s.DB.Where("expires_at <= ?", time.Now()).Delete(&models.RevokedToken{}).Error; err != nil {
+			fmt.Printf("Failed to clean up revoked tokens: %s\n", err.Error())
+		}
+	}
+}
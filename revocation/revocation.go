@@ -0,0 +1,40 @@
+// Package revocation keeps track of access tokens revoked before they expire.
+package revocation
+
+import (
+	"errors"
+	"time"
+
+	"github.com/kostya-zero/blogger/jwt"
+)
+
+var ErrRevoked = errors.New("token revoked")
+
+// Store records revoked token IDs (the jti claim). Entries only need to live
+// until the token's own expiry, after which the signature check rejects it.
+type Store interface {
+	Revoke(jti string, expiresAt time.Time) error
+	IsRevoked(jti string) (bool, error)
+}
+
+// Check returns a jwt.Check that rejects tokens present in the store.
+func Check(store Store) jwt.Check {
+	return func(claims *jwt.TokenClaims) error {
+		revoked, err := store.IsRevoked(claims.ID)
+		if err != nil {
+			return err
+		}
+		if revoked {
+			return ErrRevoked
+		}
+		return nil
+	}
+}
+
+// RevokeClaims revokes the token the claims were parsed from.
+func RevokeClaims(store Store, claims *jwt.TokenClaims) error {
+	if claims.ExpiresAt == nil {
+		return errors.New("token has no expiry")
+	}
+	return store.Revoke(claims.ID, claims.ExpiresAt.Time)
+}
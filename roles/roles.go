@@ -0,0 +1,114 @@
+// Package roles defines the roles of users and the permissions they grant.
+package roles
+
+import (
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/sessions"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+const (
+	PermModeratePosts = "posts:moderate"
+	PermModerateUsers = "users:moderate"
+	PermManageRoles   = "roles:manage"
+	PermReadAudit     = "audit:read"
+	PermManageInvites = "invites:manage"
+	PermImpersonate   = "users:impersonate"
+)
+
+// Roles lists every role, least privileged first.
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+// Permissions lists every permission a role or a user can be granted.
+var Permissions = []string{PermModeratePosts, PermModerateUsers, PermManageRoles, PermReadAudit, PermManageInvites, PermImpersonate}
+
+var grants = map[string][]string{
+	RoleUser:      nil,
+	RoleModerator: {PermModeratePosts, PermModerateUsers},
+	RoleAdmin:     Permissions,
+}
+
+var (
+	ErrUnknownRole       = errors.New("unknown role")
+	ErrUnknownPermission = errors.New("unknown permission")
+	ErrLastAdmin         = errors.New("cannot demote the last admin")
+)
+
+// Effective returns the permissions of the role together with the extra ones
+// granted to the user, sorted and without duplicates.
+func Effective(role string, extra []string) []string {
+	perms := append(slices.Clone(grants[role]), extra...)
+	slices.Sort(perms)
+	return slices.Compact(perms)
+}
+
+// Of returns the effective permissions of the user.
+func Of(user *models.User) []string {
+	return Effective(user.Role, strings.Fields(user.Permissions))
+}
+
+// Set changes the role and extra permissions of the user. Access tokens carry
+// the permissions, so new ones apply from the next refresh; if any are taken
+// away, every session of the user is revoked instead.
+func Set(db *gorm.DB, store *sessions.Store, userID uint, role string, extra []string) (*models.User, error) {
+	if !slices.Contains(Roles, role) {
+		return nil, ErrUnknownRole
+	}
+	for _, perm := range extra {
+		if !slices.Contains(Permissions, perm) {
+			return nil, ErrUnknownPermission
+		}
+	}
+
+	var user models.User
+	var lost bool
+	err := db.Transaction(func(tx *gorm.DB) error {
+		// Locking every admin keeps two admins from demoting each other at
+		// the same time.
+		var admins []models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").Where("role = ?", RoleAdmin).Find(&admins).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return err
+		}
+
+		if user.Role == RoleAdmin && role != RoleAdmin && len(admins) <= 1 {
+			return ErrLastAdmin
+		}
+
+		before := Of(&user)
+		user.Role = role
+		user.Permissions = strings.Join(Effective(RoleUser, extra), " ")
+		for _, perm := range before {
+			if !slices.Contains(Of(&user), perm) {
+				lost = true
+			}
+		}
+
+		return tx.Model(&user).Updates(map[string]any{"role": user.Role, "permissions": user.Permissions}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if lost {
+		if err := store.RevokeUser(user.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,183 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/audit"
+	"github.com/kostya-zero/blogger/dto"
+	"github.com/kostya-zero/blogger/helpers"
+	"github.com/kostya-zero/blogger/jwt"
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/moderation"
+	"github.com/kostya-zero/blogger/revocation"
+	"github.com/kostya-zero/blogger/roles"
+	"github.com/kostya-zero/blogger/sessions"
+	"github.com/kostya-zero/blogger/validation"
+	"gorm.io/gorm"
+)
+
+// impersonationTTL limits how long staff can act as a user before asking for
+// a new token.
+const impersonationTTL = 15 * time.Minute
+
+type AdminHandler struct {
+	DB       *gorm.DB
+	Keys     *jwt.KeyRing
+	Sessions *sessions.Store
+}
+
+func NewAdminHandler(db *gorm.DB, keys *jwt.KeyRing, store *sessions.Store) *AdminHandler {
+	return &AdminHandler{DB: db, Keys: keys, Sessions: store}
+}
+
+// Staff lists users with a role other than the default or extra permissions.
+func (ah *AdminHandler) Staff(c *fiber.Ctx) error {
+	var users []models.User
+	err := ah.DB.Where("role <> ? OR permissions <> ''", roles.RoleUser).Order("username").Find(&users).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not load users"})
+	}
+
+	staff := make([]fiber.Map, 0, len(users))
+	for _, user := range users {
+		staff = append(staff, fiber.Map{
+			"username":    user.Username,
+			"role":        user.Role,
+			"permissions": roles.Of(&user),
+		})
+	}
+
+	return c.JSON(staff)
+}
+
+func (ah *AdminHandler) SetRole(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var req dto.SetRoleRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
+	}
+
+	var target models.User
+	if err := ah.DB.Where("username = ?", req.Username).First(&target).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	user, err := roles.Set(ah.DB, ah.Sessions, target.ID, req.Role, req.Permissions)
+	if err != nil {
+		switch {
+		case errors.Is(err, roles.ErrUnknownRole):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Unknown role", "roles": roles.Roles})
+		case errors.Is(err, roles.ErrUnknownPermission):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Unknown permission", "permissions": roles.Permissions})
+		case errors.Is(err, roles.ErrLastAdmin):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Promote another admin first"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not change role"})
+	}
+
+	audit.Record(ah.DB, models.AuditLog{
+		UserID:  &user.ID,
+		ActorID: &claims.UserID,
+		Action:  audit.ActionRoleChange,
+		Detail:  fmt.Sprintf("%s -> %s [%s]", target.Role, user.Role, user.Permissions),
+		IP:      c.IP(),
+	})
+
+	return c.JSON(fiber.Map{
+		"username":    user.Username,
+		"role":        user.Role,
+		"permissions": roles.Of(user),
+	})
+}
+
+// Impersonate issues an access token to act as a regular user. The token is
+// bound to the admin's session, carries no permissions and is only returned
+// in the body, so the admin's own cookies stay in place.
+func (ah *AdminHandler) Impersonate(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var req dto.ImpersonateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
+	}
+
+	var target models.User
+	if err := ah.DB.Where("username = ?", req.Username).First(&target).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	if target.ID == claims.UserID || target.Role != roles.RoleUser {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only regular users can be impersonated"})
+	}
+
+	if suspension, err := moderation.Active(ah.DB, target.ID); err != nil || suspension != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Suspended users cannot be impersonated"})
+	}
+
+	token := jwt.NewClaims(target.ID, claims.SessionID, impersonationTTL)
+	token.Role = target.Role
+	token.Act = &jwt.Actor{UserID: claims.UserID}
+
+	access, err := jwt.SignToken(token, ah.Keys)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate access token"})
+	}
+
+	audit.Record(ah.DB, models.AuditLog{
+		UserID:  &target.ID,
+		ActorID: &claims.UserID,
+		Action:  audit.ActionImpersonateStart,
+		Detail:  fmt.Sprintf("token %s until %s: %s", token.ID, token.ExpiresAt.Time.Format(time.RFC3339), req.Reason),
+		IP:      c.IP(),
+	})
+
+	return c.JSON(fiber.Map{
+		"access_token":  access,
+		"expires_in":    int(impersonationTTL.Seconds()),
+		"impersonating": target.Username,
+	})
+}
+
+// StopImpersonating revokes the impersonation token the request is made with.
+func (ah *AdminHandler) StopImpersonating(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if !claims.Impersonated() {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Not impersonating anyone"})
+	}
+
+	if err := revocation.RevokeClaims(ah.Sessions.Revoked, claims); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not revoke access token"})
+	}
+
+	audit.Record(ah.DB, models.AuditLog{
+		UserID:  &claims.UserID,
+		ActorID: &claims.Act.UserID,
+		Action:  audit.ActionImpersonateStop,
+		Detail:  "token " + claims.ID,
+		IP:      c.IP(),
+	})
+
+	return c.JSON(fiber.Map{"success": 1})
+}
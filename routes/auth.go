@@ -2,37 +2,152 @@
 package routes
 
 import (
-	"crypto/rand"
-	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/kostya-zero/blogger/dto"
+	"github.com/kostya-zero/blogger/invites"
 	"github.com/kostya-zero/blogger/jwt"
+	"github.com/kostya-zero/blogger/lockout"
+	"github.com/kostya-zero/blogger/mailer"
 	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/moderation"
+	"github.com/kostya-zero/blogger/onetime"
+	"github.com/kostya-zero/blogger/password"
+	"github.com/kostya-zero/blogger/revocation"
+	"github.com/kostya-zero/blogger/roles"
+	"github.com/kostya-zero/blogger/sessions"
 	"github.com/kostya-zero/blogger/validation"
 
 	"github.com/gofiber/fiber/v2"
-	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
+const accessTokenTTL = 15 * time.Minute
+
 type AuthHandler struct {
-	DB     *gorm.DB
-	Secret string
+	DB        *gorm.DB
+	Keys      *jwt.KeyRing
+	Sessions  *sessions.Store
+	Tokens    *onetime.Store
+	Mailer    mailer.Mailer
+	Lockout   *lockout.Guard
+	Invites   *invites.Store
+	PublicURL string
+
+	// Background sends the emails of anonymous endpoints after they respond.
+	Background *mailer.Queue
+
+	// PasswordResetURL is the front-end page reset links open. It reads the
+	// token from the query and posts it with the new password.
+	PasswordResetURL string
+
+	// MagicLinkSignup lets a magic link create an account for an address
+	// that has none.
+	MagicLinkSignup bool
+}
+
+func NewAuthHandler(db *gorm.DB, keys *jwt.KeyRing, store *sessions.Store, tokens *onetime.Store, m mailer.Mailer, guard *lockout.Guard, inv *invites.Store, publicURL string) *AuthHandler {
+	return &AuthHandler{
+		DB:               db,
+		Keys:             keys,
+		Sessions:         store,
+		Tokens:           tokens,
+		Mailer:           m,
+		Lockout:          guard,
+		Invites:          inv,
+		PublicURL:        publicURL,
+		Background:       mailer.NewQueue(4, 256),
+		PasswordResetURL: publicURL + "/reset-password",
+	}
+}
+
+// refuseRegistration answers a sign up the registration mode does not allow.
+func refuseRegistration(c *fiber.Ctx, refused *invites.Error) error {
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": refused.Message, "code": refused.Code})
 }
 
-func generateRefreshToken() (string, error) {
-	b := make([]byte, 32)
-	_, err := rand.Read(b)
+// issueAccessToken signs a new access token for the session and records its
+// jti on the session. The token carries the current role of the user.
+func (h *AuthHandler) issueAccessToken(session *models.Session) (string, error) {
+	var user models.User
+	if err := h.DB.Select("id", "role", "permissions").First(&user, session.UserID).Error; err != nil {
+		return "", err
+	}
+
+	claims := jwt.NewClaims(session.UserID, session.ID, accessTokenTTL)
+	claims.SetAuth(strings.Fields(session.AMR), session.AuthTime)
+	claims.Role = user.Role
+	claims.Permissions = roles.Of(&user)
+	access, err := jwt.SignToken(claims, h.Keys)
 	if err != nil {
 		return "", err
 	}
 
-	return base64.URLEncoding.EncodeToString(b), nil
+	if err := h.Sessions.SetAccessToken(claims); err != nil {
+		return "", err
+	}
+
+	return access, nil
+}
+
+func setAuthCookies(c *fiber.Ctx, access, refreshToken string, refreshExpires time.Time) {
+	c.Cookie(&fiber.Cookie{
+		Name:     "access_token",
+		Value:    access,
+		HTTPOnly: true,
+		Expires:  time.Now().Add(accessTokenTTL),
+	})
+	c.Cookie(&fiber.Cookie{
+		Name:     "refresh_token",
+		Value:    refreshToken,
+		Path:     "/auth",
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteStrictMode,
+		Expires:  refreshExpires,
+	})
+}
+
+// respondWithTokens hands out a fresh token pair either as cookies or, for
+// clients that asked for token_mode=body, in the response body.
+func respondWithTokens(c *fiber.Ctx, mode, access, refreshToken string, session *models.Session) error {
+	if mode == "body" {
+		return c.JSON(fiber.Map{
+			"success":       1,
+			"token_type":    "Bearer",
+			"access_token":  access,
+			"expires_in":    int(accessTokenTTL.Seconds()),
+			"refresh_token": refreshToken,
+		})
+	}
+
+	setAuthCookies(c, access, refreshToken, session.ExpiresAt)
+	return c.JSON(fiber.Map{"success": 1})
 }
 
-func NewAuthHandler(db *gorm.DB, secret string) *AuthHandler {
-	return &AuthHandler{DB: db, Secret: secret}
+// parseRefreshRequest reads the optional refresh request body. Cookie clients
+// usually send none.
+func parseRefreshRequest(c *fiber.Ctx) (dto.RefreshRequest, error) {
+	var req dto.RefreshRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return req, err
+		}
+	}
+
+	if req.RefreshToken == "" {
+		req.RefreshToken = c.Cookies("refresh_token", "")
+	}
+
+	return req, nil
+}
+
+func clearAuthCookies(c *fiber.Ctx) {
+	expired := time.Now().Add(-1 * time.Hour)
+	c.Cookie(&fiber.Cookie{Name: "access_token", Value: "", HTTPOnly: true, Expires: expired})
+	c.Cookie(&fiber.Cookie{Name: "refresh_token", Value: "", Path: "/auth", HTTPOnly: true, Expires: expired})
 }
 
 func (h *AuthHandler) Register(c *fiber.Ctx) error {
@@ -45,7 +160,16 @@ func (h *AuthHandler) Register(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
 	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	var refused *invites.Error
+	if errors.As(h.Invites.Check(req.Email, req.InviteCode != ""), &refused) {
+		return refuseRegistration(c, refused)
+	}
+
+	if err := password.Check(req.Password, req.Username, req.Email); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	hash, err := password.Hash(req.Password)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not hash password"})
 	}
@@ -54,21 +178,39 @@ func (h *AuthHandler) Register(c *fiber.Ctx) error {
 		Username:     req.Username,
 		Email:        req.Email,
 		CreatedAt:    time.Now(),
-		PasswordHash: string(hash),
+		PasswordHash: hash,
 	}
 
 	if err := h.DB.Where("email = ?", user.Email).First(&models.User{}).Error; err == nil {
-		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "User with this email already exists"})
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "User with this email already exists", "code": "email_taken"})
 	}
 
 	if err := h.DB.Where("username = ?", user.Username).First(&models.User{}).Error; err == nil {
-		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "User with this username email already exists"})
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "User with this username email already exists", "code": "username_taken"})
 	}
 
-	if err := h.DB.Create(&user).Error; err != nil {
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		invite, err := h.Invites.Admit(tx, req.Email, req.InviteCode)
+		if err != nil {
+			return err
+		}
+		if invite != nil {
+			user.InviteID = &invite.ID
+		}
+		return tx.Create(&user).Error
+	})
+	if err != nil {
+		if errors.As(err, &refused) {
+			return refuseRegistration(c, refused)
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create user in database"})
 	}
 
+	// The account exists either way; the user can ask for another link.
+	if err := h.sendVerification(&user); err != nil {
+		fmt.Printf("Failed to send verification email to user %d: %s\n", user.ID, err.Error())
+	}
+
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"success": 1})
 }
 
@@ -82,90 +224,146 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
 	}
 
+	account := accountKey(req.Email)
+	if locked, err := lockedOut(c, h.Lockout, account); locked {
+		return err
+	}
+
 	var user models.User
 	if err := h.DB.Where("email = ?", req.Email).First(&user).Error; err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "User not found"})
+		password.VerifyDummy(req.Password)
+		loginFailed(c, h.DB, h.Lockout, account, nil)
+		return invalidCredentials(c)
 	}
 
-	if bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)) != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Wrong password"})
+	ok, rehash, err := password.Verify(req.Password, user.PasswordHash)
+	if err != nil {
+		fmt.Printf("Failed to verify password of user %d: %s\n", user.ID, err.Error())
+	}
+	if !ok {
+		loginFailed(c, h.DB, h.Lockout, account, &user.ID)
+		return invalidCredentials(c)
 	}
 
-	access, err := jwt.CreateToken(user.ID, h.Secret, 15*time.Minute)
-	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Could not generate access token"})
+	if rehash {
+		h.upgradePasswordHash(&user, req.Password)
 	}
 
-	refresh, err := generateRefreshToken()
+	// With two-factor enabled the counter is only reset once the second
+	// factor is correct too.
+	if h.requiresMFA(&user, []string{sessions.MethodPassword}) {
+		return h.startMFAChallenge(c, &user, []string{sessions.MethodPassword})
+	}
+
+	loginSucceeded(h.Lockout, account)
+	return h.startSession(c, &user, req.Device, req.TokenMode, []string{sessions.MethodPassword})
+}
+
+// upgradePasswordHash re-hashes a correct password with the current default
+// algorithm and parameters.
+func (h *AuthHandler) upgradePasswordHash(user *models.User, plain string) {
+	hash, err := password.Hash(plain)
+	if err == nil {
+		err = h.DB.Model(user).Update("password_hash", hash).Error
+	}
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate refresh token"})
+		fmt.Printf("Failed to upgrade password hash of user %d: %s\n", user.ID, err.Error())
 	}
+}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(refresh), bcrypt.DefaultCost)
+// startSession signs the user in on this device once every required factor
+// has been checked, unless the account is suspended.
+func (h *AuthHandler) startSession(c *fiber.Ctx, user *models.User, device, tokenMode string, amr []string) error {
+	suspension, err := moderation.Active(h.DB, user.ID)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not hash refresh token"})
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create session"})
+	}
+	if suspension != nil {
+		return h.refuseSuspended(c, user, suspension)
 	}
 
-	h.DB.Model(&user).Update("refresh_token", string(hash))
+	session, refreshToken, err := h.Sessions.Create(user.ID, sessions.Device{
+		Label:     deviceLabel(device, c.Get(fiber.HeaderUserAgent)),
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		IP:        c.IP(),
+	}, amr)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create session"})
+	}
 
-	c.Cookie(&fiber.Cookie{
-		Name:     "access_token",
-		Value:    access,
-		HTTPOnly: true,
-		Expires:  time.Now().Add(15 * time.Minute),
-	})
+	access, err := h.issueAccessToken(session)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate access token"})
+	}
 
-	return c.JSON(fiber.Map{"success": 1})
+	return respondWithTokens(c, tokenMode, access, refreshToken, session)
 }
 
 func (h *AuthHandler) Refresh(c *fiber.Ctx) error {
-	tok := c.Cookies("access_token", "")
-
-	if tok == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "No access token provided"})
-	}
-
-	claims, err := jwt.ParseToken(tok, h.Secret)
+	req, err := parseRefreshRequest(c)
 	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
 	}
 
-	userID := claims.UserID
-	var user models.User
-
-	if err := h.DB.First(&user, userID).Error; err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
 	}
 
-	if user.RefreshToken == nil || *user.RefreshToken == "" {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "No refresh token found"})
+	if req.RefreshToken == "" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "No refresh token provided"})
 	}
 
-	_, err = jwt.ParseToken(*user.RefreshToken, h.Secret)
+	session, next, err := h.Sessions.Rotate(req.RefreshToken, c.IP())
 	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid refresh token, " + err.Error()})
+		clearAuthCookies(c)
+		switch {
+		case errors.Is(err, sessions.ErrNotFound), errors.Is(err, sessions.ErrExpired), errors.Is(err, sessions.ErrRevoked):
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired refresh token"})
+		case errors.Is(err, sessions.ErrReused):
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Refresh token was already used, the session was revoked"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not rotate refresh token"})
 	}
 
-	newAccess, _ := jwt.CreateToken(userID, h.Secret, 15*time.Minute)
-	c.Cookie(&fiber.Cookie{
-		Name:     "access_token",
-		Value:    newAccess,
-		HTTPOnly: true,
-		Expires:  time.Now().Add(15 * time.Minute),
-	})
+	access, err := h.issueAccessToken(session)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate access token"})
+	}
 
-	return c.JSON(fiber.Map{"success": 1})
+	return respondWithTokens(c, req.TokenMode, access, next, session)
 }
 
 func (h *AuthHandler) Logout(c *fiber.Ctx) error {
-	tok := c.Cookies("access_token", "")
-	claims, err := jwt.ParseToken(tok, h.Secret)
+	if tok := jwt.TokenFromRequest(c); tok != "" {
+		if claims, err := jwt.ParseToken(tok, h.Keys); err == nil {
+			if err := revocation.RevokeClaims(h.Sessions.Revoked, claims); err != nil {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not revoke access token"})
+			}
+
+			// Revoke the session too, so its refresh token stops working even
+			// when the client did not send it.
+			if claims.Use == "" && claims.SessionID != 0 && !claims.Impersonated() {
+				if err := h.Sessions.Revoke(claims.UserID, claims.SessionID); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+					return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not revoke session"})
+				}
+			}
+		}
+	}
+
+	req, err := parseRefreshRequest(c)
 	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Token is invalid"})
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if req.RefreshToken != "" {
+		session, err := h.Sessions.FindByRefreshToken(req.RefreshToken)
+		if err == nil {
+			if err := h.Sessions.Revoke(session.UserID, session.ID); err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not revoke session"})
+			}
+		}
 	}
 
-	userID := claims.UserID
-	h.DB.Model(&models.User{}).Where("id = ?", userID).Update("refresh_token", "")
-	c.Cookie(&fiber.Cookie{Name: "access_token", Value: "", HTTPOnly: true, Expires: time.Now().Add(-1 * time.Hour)})
+	clearAuthCookies(c)
 	return c.SendStatus(fiber.StatusOK)
 }
@@ -0,0 +1,54 @@
+package routes
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginUpgradesLegacyHash(t *testing.T) {
+	h := newTestAuthHandler(t)
+	user := createTestUser(t, h, "reader", "reader@example.com")
+
+	legacyHash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("bcrypt: %v", err)
+	}
+	if err := h.DB.Model(user).Update("password_hash", string(legacyHash)).Error; err != nil {
+		t.Fatalf("store bcrypt hash: %v", err)
+	}
+
+	app := fiber.New()
+	app.Post("/auth/login", h.Login)
+
+	status, body := postJSON(t, app, "/auth/login", map[string]any{
+		"email":      user.Email,
+		"password":   "correct horse",
+		"token_mode": "body",
+	})
+	if status != http.StatusOK || body["access_token"] == nil {
+		t.Fatalf("login = %d %v, want tokens", status, body)
+	}
+
+	var stored models.User
+	if err := h.DB.First(&stored, user.ID).Error; err != nil {
+		t.Fatalf("reload user: %v", err)
+	}
+	if !strings.HasPrefix(stored.PasswordHash, "$argon2id$") {
+		t.Fatalf("stored hash = %q, want argon2id", stored.PasswordHash)
+	}
+
+	// The new hash works for the next login.
+	status, body = postJSON(t, app, "/auth/login", map[string]any{
+		"email":      user.Email,
+		"password":   "correct horse",
+		"token_mode": "body",
+	})
+	if status != http.StatusOK || body["access_token"] == nil {
+		t.Fatalf("second login = %d %v, want tokens", status, body)
+	}
+}
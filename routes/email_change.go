@@ -0,0 +1,184 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/dto"
+	"github.com/kostya-zero/blogger/helpers"
+	"github.com/kostya-zero/blogger/mailer"
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/onetime"
+	"github.com/kostya-zero/blogger/validation"
+	"gorm.io/gorm"
+)
+
+const (
+	emailChangeTTL = 24 * time.Hour
+	emailCancelTTL = 7 * 24 * time.Hour
+)
+
+// UpdateEmail starts an email change. The new address only replaces the old
+// one once confirmed through the link sent to it; the old address is told
+// about the change and can cancel it.
+func (sh *SettingsHandler) UpdateEmail(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var req dto.UpdateEmailRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
+	}
+
+	var user models.User
+	if err := sh.DB.First(&user, claims.UserID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	if ok, err := sh.confirmSudo(c, claims, &user, req.CurrentPassword); !ok {
+		return err
+	}
+
+	if strings.EqualFold(req.Email, user.Email) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "This is already your email address"})
+	}
+
+	var count int64
+	sh.DB.Model(&models.User{}).Where("email = ?", req.Email).Count(&count)
+	if count > 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "User with this email already exists"})
+	}
+
+	// Only the latest request can be confirmed or cancelled.
+	for _, purpose := range []string{onetime.PurposeConfirmEmail, onetime.PurposeCancelEmail} {
+		if err := sh.Tokens.Invalidate(user.ID, purpose); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+		}
+	}
+
+	if err := sh.DB.Model(&user).Update("pending_email", req.Email).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update email"})
+	}
+
+	confirm, err := sh.Tokens.Issue(user.ID, onetime.PurposeConfirmEmail, req.Email, emailChangeTTL)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate confirmation link"})
+	}
+
+	cancel, err := sh.Tokens.Issue(user.ID, onetime.PurposeCancelEmail, req.Email, emailCancelTTL)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate cancellation link"})
+	}
+
+	err = sh.Mailer.Send(mailer.Message{
+		To:      req.Email,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Hi %s,\n\nconfirm that this is the new email address of your Blogger account by opening this link:\n\n%s/settings/confirm-email?token=%s\n\nThe link expires in 24 hours.\n",
+			user.Username, sh.PublicURL, confirm),
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not send confirmation email"})
+	}
+
+	err = sh.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Your email address is being changed",
+		Body: fmt.Sprintf("Hi %s,\n\nsomeone asked to change the email address of your Blogger account to %s. If it wasn't you, cancel the change with this link and reset your password:\n\n%s/settings/cancel-email-change?token=%s\n",
+			user.Username, req.Email, sh.PublicURL, cancel),
+	})
+	if err != nil {
+		fmt.Printf("Failed to notify user %d about email change: %s\n", user.ID, err.Error())
+	}
+
+	return c.JSON(fiber.Map{"success": 1})
+}
+
+func (sh *SettingsHandler) ConfirmEmail(c *fiber.Ctx) error {
+	raw := c.Query("token", "")
+	if raw == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The 'token' parameter is required"})
+	}
+
+	token, err := sh.Tokens.Lookup(raw, onetime.PurposeConfirmEmail)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired confirmation link"})
+	}
+
+	// The token is only used up if the address actually changes, so a
+	// conflict leaves the link working.
+	err = sh.DB.Transaction(func(tx *gorm.DB) error {
+		if _, err := sh.Tokens.ConsumeTx(tx, raw, onetime.PurposeConfirmEmail); err != nil {
+			return err
+		}
+
+		result := tx.Model(&models.User{}).
+			Where("id = ? AND pending_email = ?", token.UserID, token.Email).
+			Updates(map[string]any{
+				"email":         token.Email,
+				"pending_email": nil,
+				"verified_at":   time.Now(),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return onetime.ErrInvalidToken
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "User with this email already exists"})
+		}
+		if errors.Is(err, onetime.ErrInvalidToken) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired confirmation link"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update email"})
+	}
+
+	// Links sent to the old address must not work anymore.
+	for _, purpose := range []string{onetime.PurposeResetPassword, onetime.PurposeVerifyEmail, onetime.PurposeCancelEmail} {
+		if err := sh.Tokens.Invalidate(*token.UserID, purpose); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+		}
+	}
+
+	return c.JSON(fiber.Map{"success": 1})
+}
+
+func (sh *SettingsHandler) CancelEmailChange(c *fiber.Ctx) error {
+	raw := c.Query("token", "")
+	if raw == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The 'token' parameter is required"})
+	}
+
+	token, err := sh.Tokens.Consume(raw, onetime.PurposeCancelEmail)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired cancellation link"})
+	}
+
+	result := sh.DB.Model(&models.User{}).
+		Where("id = ? AND pending_email = ?", token.UserID, token.Email).
+		Update("pending_email", nil)
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to cancel email change"})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "There is no pending email change to cancel"})
+	}
+
+	if err := sh.Tokens.Invalidate(*token.UserID, onetime.PurposeConfirmEmail); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+	}
+
+	return c.JSON(fiber.Map{"success": 1})
+}
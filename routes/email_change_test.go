@@ -0,0 +1,61 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/onetime"
+)
+
+func TestConfirmEmailConflict(t *testing.T) {
+	h := newTestAuthHandler(t)
+	sh := newTestSettingsHandler(h)
+	user := createTestUser(t, h, "reader", "reader@example.com")
+
+	if err := h.DB.Model(user).Update("pending_email", "new@example.com").Error; err != nil {
+		t.Fatalf("set pending email: %v", err)
+	}
+	token, err := sh.Tokens.Issue(user.ID, onetime.PurposeConfirmEmail, "new@example.com", emailChangeTTL)
+	if err != nil {
+		t.Fatalf("issue token: %v", err)
+	}
+
+	app := fiber.New()
+	app.Get("/settings/confirm-email", sh.ConfirmEmail)
+	confirm := func() int {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/settings/confirm-email?token="+token, nil), -1)
+		if err != nil {
+			t.Fatalf("GET /settings/confirm-email: %v", err)
+		}
+		return resp.StatusCode
+	}
+
+	// Someone else registered the address after the change was requested.
+	rival := createTestUser(t, h, "rival", "new@example.com")
+	if status := confirm(); status != http.StatusConflict {
+		t.Fatalf("confirm taken address = %d, want %d", status, http.StatusConflict)
+	}
+
+	// The link still works once the address is free again.
+	if err := h.DB.Model(rival).Update("email", "rival@example.com").Error; err != nil {
+		t.Fatalf("free address: %v", err)
+	}
+	if status := confirm(); status != http.StatusOK {
+		t.Fatalf("confirm = %d, want %d", status, http.StatusOK)
+	}
+
+	var updated models.User
+	if err := h.DB.First(&updated, user.ID).Error; err != nil {
+		t.Fatalf("load user: %v", err)
+	}
+	if updated.Email != "new@example.com" || updated.PendingEmail != nil {
+		t.Fatalf("email = %q, pending %v, want new@example.com and none", updated.Email, updated.PendingEmail)
+	}
+
+	if status := confirm(); status != http.StatusBadRequest {
+		t.Fatalf("confirm twice = %d, want %d", status, http.StatusBadRequest)
+	}
+}
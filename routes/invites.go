@@ -0,0 +1,115 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/dto"
+	"github.com/kostya-zero/blogger/helpers"
+	"github.com/kostya-zero/blogger/invites"
+	"github.com/kostya-zero/blogger/roles"
+	"github.com/kostya-zero/blogger/validation"
+	"gorm.io/gorm"
+)
+
+// defaultInviteTTL applies when an invite is created without an expiry.
+const defaultInviteTTL = 7 * 24 * time.Hour
+
+type InvitesHandler struct {
+	Invites *invites.Store
+
+	// PerUser is how many usable single-use invites a user without the
+	// invites:manage permission may hold at once. Zero disables minting
+	// for them.
+	PerUser int
+}
+
+func NewInvitesHandler(store *invites.Store, perUser int) *InvitesHandler {
+	return &InvitesHandler{Invites: store, PerUser: perUser}
+}
+
+func (ih *InvitesHandler) Create(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var req dto.CreateInviteRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
+	}
+
+	maxUses := max(req.MaxUses, 1)
+	ttl := defaultInviteTTL
+	if req.ExpiresInDays > 0 {
+		ttl = time.Duration(req.ExpiresInDays) * 24 * time.Hour
+	}
+
+	if !claims.HasPermission(roles.PermManageInvites) {
+		if maxUses > 1 || ttl > defaultInviteTTL {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": fmt.Sprintf("You can only create single-use invites valid for up to %d days", int(defaultInviteTTL.Hours()/24)),
+			})
+		}
+
+		outstanding, err := ih.Invites.Outstanding(claims.UserID)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not load invites"})
+		}
+		if outstanding >= int64(ih.PerUser) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": fmt.Sprintf("You can have at most %d unused invites", ih.PerUser),
+			})
+		}
+	}
+
+	expiresAt := time.Now().Add(ttl)
+	code, invite, err := ih.Invites.Create(&claims.UserID, maxUses, &expiresAt)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create invite"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"code": code, "details": invite})
+}
+
+func (ih *InvitesHandler) List(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	list, err := ih.Invites.List(claims.UserID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not load invites"})
+	}
+
+	return c.JSON(list)
+}
+
+func (ih *InvitesHandler) Revoke(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	inviteID, err := strconv.ParseUint(c.Query("id", ""), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The 'id' parameter is required"})
+	}
+
+	if err := ih.Invites.Revoke(claims.UserID, uint(inviteID)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Invite not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not revoke invite"})
+	}
+
+	return c.JSON(fiber.Map{"success": 1})
+}
@@ -0,0 +1,80 @@
+package routes
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/audit"
+	"github.com/kostya-zero/blogger/lockout"
+	"github.com/kostya-zero/blogger/models"
+	"gorm.io/gorm"
+)
+
+func invalidCredentials(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid credentials"})
+}
+
+func accountKey(email string) string {
+	return lockout.AccountKey(strings.ToLower(email))
+}
+
+// lockedOut responds with 429 if the account or the client's IP is locked.
+func lockedOut(c *fiber.Ctx, guard *lockout.Guard, account string) (bool, error) {
+	var wait time.Duration
+	for _, check := range []struct {
+		limiter *lockout.Limiter
+		key     string
+	}{
+		{guard.Accounts, account},
+		{guard.IPs, lockout.IPKey(c.IP())},
+	} {
+		remaining, err := check.limiter.LockedFor(check.key)
+		if err != nil {
+			return true, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+		}
+		wait = max(wait, remaining)
+	}
+
+	if wait == 0 {
+		return false, nil
+	}
+
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(wait.Seconds())+1))
+	return true, c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "Too many failed attempts, try again later"})
+}
+
+// loginFailed counts a failed attempt against the account and the IP and
+// audits any lockout it causes. userID is nil for unknown accounts.
+func loginFailed(c *fiber.Ctx, db *gorm.DB, guard *lockout.Guard, account string, userID *uint) {
+	for _, fail := range []struct {
+		limiter *lockout.Limiter
+		key     string
+	}{
+		{guard.Accounts, account},
+		{guard.IPs, lockout.IPKey(c.IP())},
+	} {
+		delay, err := fail.limiter.Fail(fail.key)
+		if err != nil {
+			fmt.Printf("Failed to record login failure for %s: %s\n", fail.key, err.Error())
+			continue
+		}
+
+		if delay > 0 {
+			audit.Record(db, models.AuditLog{
+				UserID: userID,
+				Action: audit.ActionLoginLockout,
+				Detail: fmt.Sprintf("%s locked for %s", fail.key, delay),
+				IP:     c.IP(),
+			})
+		}
+	}
+}
+
+func loginSucceeded(guard *lockout.Guard, account string) {
+	if err := guard.Accounts.Succeed(account); err != nil {
+		fmt.Printf("Failed to reset login failures for %s: %s\n", account, err.Error())
+	}
+}
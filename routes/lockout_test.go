@@ -0,0 +1,49 @@
+package routes
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/lockout"
+)
+
+func TestAccountKeyIgnoresCase(t *testing.T) {
+	if got, want := accountKey("Reader@Example.COM"), lockout.AccountKey("reader@example.com"); got != want {
+		t.Fatalf("accountKey = %q, want %q", got, want)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	h := newTestAuthHandler(t)
+	user := createTestUser(t, h, "reader", "reader@example.com")
+
+	app := fiber.New()
+	app.Post("/auth/login", h.Login)
+
+	login := func(email, password string) (int, map[string]any) {
+		return postJSON(t, app, "/auth/login", map[string]any{
+			"email":      email,
+			"password":   password,
+			"token_mode": "body",
+		})
+	}
+
+	// Failures spelled in any case count against the same account, and a
+	// successful login resets them.
+	for range h.Lockout.Accounts.Threshold - 1 {
+		if status, _ := login("READER@example.com", "wrong"); status != http.StatusUnauthorized {
+			t.Fatalf("wrong password = %d, want %d", status, http.StatusUnauthorized)
+		}
+	}
+	if status, body := login(user.Email, "correct horse"); status != http.StatusOK {
+		t.Fatalf("login = %d %v", status, body)
+	}
+
+	for range h.Lockout.Accounts.Threshold {
+		login("Reader@Example.com", "wrong")
+	}
+	if status, _ := login(user.Email, "correct horse"); status != http.StatusTooManyRequests {
+		t.Fatalf("login while locked = %d, want %d", status, http.StatusTooManyRequests)
+	}
+}
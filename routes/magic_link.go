@@ -0,0 +1,184 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/dto"
+	"github.com/kostya-zero/blogger/invites"
+	"github.com/kostya-zero/blogger/mailer"
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/onetime"
+	"github.com/kostya-zero/blogger/sessions"
+	"github.com/kostya-zero/blogger/validation"
+	"gorm.io/gorm"
+)
+
+// magicLinkTTL is short because the link alone signs the user in.
+const magicLinkTTL = 15 * time.Minute
+
+// sendMagicLink emails a sign-in link to the address. user is nil for
+// addresses without an account, which only get a link when sign up through
+// magic links is enabled.
+func (h *AuthHandler) sendMagicLink(email string, user *models.User) error {
+	// Throttled per address like verification emails, and silently so the
+	// response stays the same.
+	now := time.Now()
+	recent, err := h.Tokens.IssuedToEmailSince(email, onetime.PurposeMagicLogin, now.Add(-resendInterval))
+	if err != nil {
+		return err
+	}
+	hourly, err := h.Tokens.IssuedToEmailSince(email, onetime.PurposeMagicLogin, now.Add(-time.Hour))
+	if err != nil {
+		return err
+	}
+	if recent > 0 || hourly >= resendPerHour {
+		return nil
+	}
+
+	var token, greeting string
+	if user != nil {
+		token, err = h.Tokens.Issue(user.ID, onetime.PurposeMagicLogin, email, magicLinkTTL)
+		greeting = "Hi " + user.Username + ",\n\nopen this link to sign in to Blogger:"
+	} else {
+		token, err = h.Tokens.IssueForEmail(onetime.PurposeMagicLogin, email, magicLinkTTL)
+		greeting = "Hi,\n\nopen this link to create your Blogger account and sign in:"
+	}
+	if err != nil {
+		return err
+	}
+
+	return h.Mailer.Send(mailer.Message{
+		To:      email,
+		Subject: "Sign in to Blogger",
+		Body: fmt.Sprintf("%s\n\n%s/auth/magic-link/verify?token=%s\n\nThe link expires in 15 minutes and works once. If you did not ask for it, ignore this email.\n",
+			greeting, h.PublicURL, token),
+	})
+}
+
+func (h *AuthHandler) MagicLink(c *fiber.Ctx) error {
+	var req dto.MagicLinkRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
+	}
+
+	// Same as ForgotPassword: the response must not reveal whether the
+	// address is registered, and the email outlives the request buffer.
+	email := strings.Clone(req.Email)
+	queued := h.Background.Submit(func() error {
+		var user models.User
+		err := h.DB.Where("email = ?", email).First(&user).Error
+		switch {
+		case err == nil:
+			err = h.sendMagicLink(email, &user)
+		case errors.Is(err, gorm.ErrRecordNotFound) && h.MagicLinkSignup && h.Invites.Check(email, false) == nil:
+			err = h.sendMagicLink(email, nil)
+		default:
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("magic link: %w", err)
+		}
+		return nil
+	})
+	if !queued {
+		fmt.Println("Email queue is full, dropped a magic link request")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": 1,
+		"message": "If you can sign in with this email, a link was sent to it.",
+	})
+}
+
+// VerifyMagicLink signs in the owner of the address the link was sent to,
+// creating the account first if the link was sent for sign up.
+func (h *AuthHandler) VerifyMagicLink(c *fiber.Ctx) error {
+	raw := c.Query("token", "")
+	if raw == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The 'token' parameter is required"})
+	}
+
+	tokenMode := c.Query("token_mode", "")
+	if tokenMode != "" && tokenMode != "cookie" && tokenMode != "body" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "TokenMode must be one of [cookie body]"})
+	}
+
+	token, err := h.Tokens.Consume(raw, onetime.PurposeMagicLogin)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired sign in link"})
+	}
+
+	var user models.User
+	err = h.DB.Where("email = ?", token.Email).First(&user).Error
+	switch {
+	case err == nil:
+		// The address may have moved to another account since the link
+		// was sent.
+		if token.UserID != nil && *token.UserID != user.ID {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired sign in link"})
+		}
+
+	case errors.Is(err, gorm.ErrRecordNotFound) && token.UserID == nil && h.MagicLinkSignup:
+		// The mode may have changed since the link was sent.
+		var refused *invites.Error
+		if errors.As(h.Invites.Check(token.Email, false), &refused) {
+			return refuseRegistration(c, refused)
+		}
+
+		if err := h.createFromEmail(token.Email, &user); err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Could not create account, try again"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create user in database"})
+		}
+
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired sign in link"})
+
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+	}
+
+	// Following the link proves the address belongs to the user.
+	if user.VerifiedAt == nil {
+		now := time.Now()
+		if err := h.DB.Model(&user).Update("verified_at", now).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not verify email"})
+		}
+	}
+
+	amr := []string{sessions.MethodMagicLink}
+	if h.requiresMFA(&user, amr) {
+		return h.startMFAChallenge(c, &user, amr)
+	}
+
+	return h.startSession(c, &user, "", tokenMode, amr)
+}
+
+// createFromEmail registers a user without a password for an address that
+// followed a sign up link.
+func (h *AuthHandler) createFromEmail(email string, user *models.User) error {
+	local, _, _ := strings.Cut(email, "@")
+	username, err := availableUsername(h.DB, local)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	*user = models.User{
+		Username:   username,
+		Email:      email,
+		CreatedAt:  now,
+		VerifiedAt: &now,
+	}
+
+	return h.DB.Create(user).Error
+}
@@ -0,0 +1,352 @@
+package routes
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/dto"
+	"github.com/kostya-zero/blogger/helpers"
+	"github.com/kostya-zero/blogger/jwt"
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/sessions"
+	"github.com/kostya-zero/blogger/totp"
+	"github.com/kostya-zero/blogger/validation"
+	"gorm.io/gorm"
+)
+
+const (
+	mfaChallengeTTL   = 5 * time.Minute
+	recoveryCodeCount = 10
+	totpIssuer        = "Blogger"
+)
+
+var errInvalidMFACode = errors.New("invalid code")
+
+type MFAHandler struct {
+	DB *gorm.DB
+}
+
+func NewMFAHandler(db *gorm.DB) *MFAHandler {
+	return &MFAHandler{DB: db}
+}
+
+// startMFAChallenge answers a correct first factor with a short-lived token
+// that must be exchanged together with a second factor at /auth/login/mfa.
+// amr lists the methods checked so far.
+func (h *AuthHandler) startMFAChallenge(c *fiber.Ctx, user *models.User, amr []string) error {
+	claims := jwt.NewClaims(user.ID, 0, mfaChallengeTTL)
+	claims.Use = "mfa"
+	claims.SetAuth(amr, time.Now())
+
+	token, err := jwt.SignToken(claims, h.Keys)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate MFA token"})
+	}
+
+	return c.JSON(fiber.Map{
+		"mfa_required": true,
+		"mfa_token":    token,
+		"mfa_methods":  h.mfaMethods(user, amr),
+		"expires_in":   int(mfaChallengeTTL.Seconds()),
+	})
+}
+
+// mfaMethods lists the second factors the user can answer a challenge with:
+// TOTP and recovery codes once TOTP is enabled, and any passkey. A passkey
+// doesn't count as a second factor if it was the first one.
+func (h *AuthHandler) mfaMethods(user *models.User, amr []string) []string {
+	var methods []string
+	if user.TOTPEnabledAt != nil {
+		methods = append(methods, "totp", "recovery_code")
+	}
+	if slices.Contains(amr, sessions.MethodHardware) {
+		return methods
+	}
+
+	var passkeys int64
+	h.DB.Model(&models.Passkey{}).Where("user_id = ?", user.ID).Count(&passkeys)
+	if passkeys > 0 {
+		methods = append(methods, "passkey")
+	}
+
+	return methods
+}
+
+// requiresMFA reports whether a sign in with the methods in amr needs a second
+// factor, which is when the user has one left to use.
+func (h *AuthHandler) requiresMFA(user *models.User, amr []string) bool {
+	return len(h.mfaMethods(user, amr)) > 0
+}
+
+// mfaUser returns the user and claims of a valid MFA challenge token.
+func (h *AuthHandler) mfaUser(mfaToken string) (*models.User, *jwt.TokenClaims, error) {
+	claims, err := jwt.ParseToken(mfaToken, h.Keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	if claims.Use != "mfa" {
+		return nil, nil, errors.New("not an MFA token")
+	}
+
+	var user models.User
+	if err := h.DB.First(&user, claims.UserID).Error; err != nil {
+		return nil, nil, err
+	}
+	if !h.requiresMFA(&user, claims.AMR) {
+		return nil, nil, errors.New("two-factor authentication is not enabled")
+	}
+
+	return &user, claims, nil
+}
+
+func (h *AuthHandler) LoginMFA(c *fiber.Ctx) error {
+	var req dto.LoginMFARequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
+	}
+
+	user, claims, err := h.mfaUser(req.MFAToken)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired MFA token"})
+	}
+
+	account := accountKey(user.Email)
+	if locked, err := lockedOut(c, h.Lockout, account); locked {
+		return err
+	}
+
+	method := "recovery_code"
+	if req.Code != "" {
+		method = "totp"
+	}
+	if !slices.Contains(h.mfaMethods(user, claims.AMR), method) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Two-factor authentication with a code is not enabled"})
+	}
+
+	amr := append(claims.AMR, sessions.MethodMFA)
+	if req.Code != "" {
+		err = useTOTPCode(h.DB, user, req.Code)
+		amr = append(amr, sessions.MethodOTP)
+	} else {
+		err = useRecoveryCode(h.DB, user.ID, req.RecoveryCode)
+	}
+	if err != nil {
+		if errors.Is(err, errInvalidMFACode) {
+			loginFailed(c, h.DB, h.Lockout, account, &user.ID)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid code"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not check code"})
+	}
+
+	loginSucceeded(h.Lockout, account)
+	return h.startSession(c, user, req.Device, req.TokenMode, amr)
+}
+
+// useTOTPCode accepts a code at most once: the counter it belongs to is
+// recorded and older or equal counters are rejected afterwards.
+func useTOTPCode(db *gorm.DB, user *models.User, code string) error {
+	if user.TOTPSecret == nil {
+		return errInvalidMFACode
+	}
+
+	counter, ok := totp.Validate(code, *user.TOTPSecret, time.Now(), user.TOTPLastCounter)
+	if !ok {
+		return errInvalidMFACode
+	}
+
+	result := db.Model(&models.User{}).
+		Where("id = ? AND totp_last_counter < ?", user.ID, counter).
+		Update("totp_last_counter", counter)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errInvalidMFACode
+	}
+
+	user.TOTPLastCounter = counter
+	return nil
+}
+
+func useRecoveryCode(db *gorm.DB, userID uint, code string) error {
+	result := db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, helpers.HashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errInvalidMFACode
+	}
+	return nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
+
+// replaceRecoveryCodes drops every recovery code of the user and returns a new
+// set. Only hashes are stored.
+func replaceRecoveryCodes(db *gorm.DB, userID uint) ([]string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	rows := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	for range recoveryCodeCount {
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(secret[:5] + "-" + secret[5:10])
+		codes = append(codes, code)
+		rows = append(rows, models.RecoveryCode{
+			UserID:    userID,
+			CodeHash:  helpers.HashToken(normalizeRecoveryCode(code)),
+			CreatedAt: time.Now(),
+		})
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&rows).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// Setup generates a new TOTP secret. It takes effect only after Confirm.
+func (mh *MFAHandler) Setup(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var user models.User
+	if err := mh.DB.First(&user, claims.UserID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	if user.TOTPEnabledAt != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Two-factor authentication is already enabled"})
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate secret"})
+	}
+
+	if err := mh.DB.Model(&user).Updates(map[string]any{"totp_secret": secret, "totp_last_counter": 0}).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not save secret"})
+	}
+
+	return c.JSON(fiber.Map{
+		"secret": secret,
+		"uri":    totp.URI(totpIssuer, user.Email, secret),
+	})
+}
+
+// Confirm enables two-factor authentication once the user proves their
+// authenticator produces valid codes, and returns the recovery codes.
+func (mh *MFAHandler) Confirm(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var req dto.TOTPCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
+	}
+
+	var user models.User
+	if err := mh.DB.First(&user, claims.UserID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	if user.TOTPEnabledAt != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Two-factor authentication is already enabled"})
+	}
+
+	if user.TOTPSecret == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Start the setup first"})
+	}
+
+	if err := useTOTPCode(mh.DB, &user, req.Code); err != nil {
+		if errors.Is(err, errInvalidMFACode) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid code"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not check code"})
+	}
+
+	codes, err := replaceRecoveryCodes(mh.DB, user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate recovery codes"})
+	}
+
+	if err := mh.DB.Model(&user).Update("totp_enabled_at", time.Now()).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not enable two-factor authentication"})
+	}
+
+	return c.JSON(fiber.Map{"success": 1, "recovery_codes": codes})
+}
+
+func (mh *MFAHandler) Disable(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	err = mh.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", claims.UserID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.User{}).Where("id = ?", claims.UserID).Updates(map[string]any{
+			"totp_secret":       nil,
+			"totp_enabled_at":   nil,
+			"totp_last_counter": 0,
+		}).Error
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not disable two-factor authentication"})
+	}
+
+	return c.JSON(fiber.Map{"success": 1})
+}
+
+func (mh *MFAHandler) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var user models.User
+	if err := mh.DB.First(&user, claims.UserID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	if user.TOTPEnabledAt == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Two-factor authentication is not enabled"})
+	}
+
+	codes, err := replaceRecoveryCodes(mh.DB, user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate recovery codes"})
+	}
+
+	return c.JSON(fiber.Map{"success": 1, "recovery_codes": codes})
+}
@@ -0,0 +1,95 @@
+package routes
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/totp"
+)
+
+// enableTOTP turns on two-factor authentication for the user.
+func enableTOTP(t *testing.T, h *AuthHandler, user *models.User) string {
+	t.Helper()
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("generate secret: %v", err)
+	}
+
+	now := time.Now()
+	user.TOTPSecret = &secret
+	user.TOTPEnabledAt = &now
+	if err := h.DB.Save(user).Error; err != nil {
+		t.Fatalf("enable TOTP: %v", err)
+	}
+	return secret
+}
+
+func TestTOTPCodeReplay(t *testing.T) {
+	h := newTestAuthHandler(t)
+	user := createTestUser(t, h, "reader", "reader@example.com")
+	secret := enableTOTP(t, h, user)
+
+	code, err := totp.Code(secret, time.Now().Unix()/30)
+	if err != nil {
+		t.Fatalf("code: %v", err)
+	}
+
+	if err := useTOTPCode(h.DB, user, code); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+
+	// A fresh copy of the user, as the next request would load it.
+	var reloaded models.User
+	if err := h.DB.First(&reloaded, user.ID).Error; err != nil {
+		t.Fatalf("reload user: %v", err)
+	}
+	if reloaded.TOTPLastCounter != user.TOTPLastCounter || reloaded.TOTPLastCounter == 0 {
+		t.Fatalf("totp_last_counter = %d, want %d", reloaded.TOTPLastCounter, user.TOTPLastCounter)
+	}
+	if err := useTOTPCode(h.DB, &reloaded, code); !errors.Is(err, errInvalidMFACode) {
+		t.Fatalf("replay = %v, want %v", err, errInvalidMFACode)
+	}
+
+	// A request that loaded the user before the first use must lose too.
+	stale := *user
+	stale.TOTPLastCounter = 0
+	if err := useTOTPCode(h.DB, &stale, code); !errors.Is(err, errInvalidMFACode) {
+		t.Fatalf("concurrent replay = %v, want %v", err, errInvalidMFACode)
+	}
+}
+
+func TestRecoveryCodeSingleUse(t *testing.T) {
+	h := newTestAuthHandler(t)
+	user := createTestUser(t, h, "reader", "reader@example.com")
+
+	codes, err := replaceRecoveryCodes(h.DB, user.ID)
+	if err != nil {
+		t.Fatalf("recovery codes: %v", err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(codes), recoveryCodeCount)
+	}
+
+	// Codes are accepted however the user types them, but only once.
+	if err := useRecoveryCode(h.DB, user.ID, " "+strings.ToUpper(codes[0])+" "); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := useRecoveryCode(h.DB, user.ID, codes[0]); !errors.Is(err, errInvalidMFACode) {
+		t.Fatalf("second use = %v, want %v", err, errInvalidMFACode)
+	}
+	if err := useRecoveryCode(h.DB, user.ID, codes[1]); err != nil {
+		t.Fatalf("other code: %v", err)
+	}
+
+	// Regenerating drops the old codes.
+	if _, err := replaceRecoveryCodes(h.DB, user.ID); err != nil {
+		t.Fatalf("regenerate: %v", err)
+	}
+	if err := useRecoveryCode(h.DB, user.ID, codes[2]); !errors.Is(err, errInvalidMFACode) {
+		t.Fatalf("old code after regenerating = %v, want %v", err, errInvalidMFACode)
+	}
+}
@@ -0,0 +1,217 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/audit"
+	"github.com/kostya-zero/blogger/dto"
+	"github.com/kostya-zero/blogger/helpers"
+	"github.com/kostya-zero/blogger/jwt"
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/moderation"
+	"github.com/kostya-zero/blogger/roles"
+	"github.com/kostya-zero/blogger/sessions"
+	"github.com/kostya-zero/blogger/validation"
+	"gorm.io/gorm"
+)
+
+// appealTokenTTL is how long a suspended user who signed in has to appeal.
+const appealTokenTTL = time.Hour
+
+// refuseSuspended answers a sign in of a suspended user with the reason and a
+// token to appeal with, so that appeals come from the account owner only.
+func (h *AuthHandler) refuseSuspended(c *fiber.Ctx, user *models.User, suspension *models.Suspension) error {
+	resp := fiber.Map{
+		"error":      "Your account is suspended",
+		"reason":     suspension.Reason,
+		"expires_at": suspension.ExpiresAt,
+	}
+
+	if suspension.AppealedAt == nil {
+		claims := jwt.NewClaims(user.ID, 0, appealTokenTTL)
+		claims.Use = "appeal"
+		if token, err := jwt.SignToken(claims, h.Keys); err == nil {
+			resp["appeal_token"] = token
+		}
+	}
+
+	return c.Status(fiber.StatusForbidden).JSON(resp)
+}
+
+func (h *AuthHandler) Appeal(c *fiber.Ctx) error {
+	var req dto.AppealRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
+	}
+
+	claims, err := jwt.ParseToken(req.AppealToken, h.Keys)
+	if err != nil || claims.Use != "appeal" {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired appeal token"})
+	}
+
+	suspension, err := moderation.Appeal(h.DB, claims.UserID, req.Appeal)
+	if err != nil {
+		switch {
+		case errors.Is(err, moderation.ErrNotSuspended):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Your account is not suspended"})
+		case errors.Is(err, moderation.ErrAlreadyAppealed):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "You already appealed this suspension"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not record appeal"})
+	}
+
+	audit.Record(h.DB, models.AuditLog{
+		UserID: &claims.UserID,
+		Action: audit.ActionAppeal,
+		Detail: fmt.Sprintf("suspension %d: %s", suspension.ID, req.Appeal),
+		IP:     c.IP(),
+	})
+
+	return c.JSON(fiber.Map{"success": 1})
+}
+
+type ModerationHandler struct {
+	DB       *gorm.DB
+	Sessions *sessions.Store
+}
+
+func NewModerationHandler(db *gorm.DB, store *sessions.Store) *ModerationHandler {
+	return &ModerationHandler{DB: db, Sessions: store}
+}
+
+// target loads the user a moderation request is about. Moderators may act on
+// regular users only, admins on anyone but themselves.
+func (mh *ModerationHandler) target(c *fiber.Ctx, claims *jwt.TokenClaims, username string) (*models.User, error) {
+	var user models.User
+	if err := mh.DB.Where("username = ?", username).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+		}
+		return nil, c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not retrieve user data"})
+	}
+
+	if user.ID == claims.UserID {
+		return nil, c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "You cannot moderate yourself"})
+	}
+	if user.Role != roles.RoleUser && claims.Role != roles.RoleAdmin {
+		return nil, c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only admins can moderate staff"})
+	}
+
+	return &user, nil
+}
+
+func (mh *ModerationHandler) Suspend(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var req dto.SuspendRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
+	}
+
+	user, err := mh.target(c, claims, req.Username)
+	if user == nil {
+		return err
+	}
+
+	var until *time.Time
+	if req.DurationHours > 0 {
+		t := time.Now().Add(time.Duration(req.DurationHours) * time.Hour)
+		until = &t
+	}
+
+	suspension, err := moderation.Suspend(mh.DB, mh.Sessions, user.ID, claims.UserID, req.Reason, until, req.HidePosts)
+	if err != nil {
+		if errors.Is(err, moderation.ErrAlreadySuspended) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "User is already suspended"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not suspend user"})
+	}
+
+	duration := "permanently"
+	if until != nil {
+		duration = "until " + until.Format(time.RFC3339)
+	}
+	audit.Record(mh.DB, models.AuditLog{
+		UserID:  &user.ID,
+		ActorID: &claims.UserID,
+		Action:  audit.ActionSuspend,
+		Detail:  fmt.Sprintf("suspension %d %s (hide posts: %t): %s", suspension.ID, duration, req.HidePosts, req.Reason),
+		IP:      c.IP(),
+	})
+
+	return c.Status(fiber.StatusCreated).JSON(suspension)
+}
+
+func (mh *ModerationHandler) Unsuspend(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var req dto.UnsuspendRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
+	}
+
+	user, err := mh.target(c, claims, req.Username)
+	if user == nil {
+		return err
+	}
+
+	suspension, err := moderation.Lift(mh.DB, user.ID, claims.UserID, req.Note)
+	if err != nil {
+		if errors.Is(err, moderation.ErrNotSuspended) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "User is not suspended"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not lift suspension"})
+	}
+
+	audit.Record(mh.DB, models.AuditLog{
+		UserID:  &user.ID,
+		ActorID: &claims.UserID,
+		Action:  audit.ActionUnsuspend,
+		Detail:  fmt.Sprintf("suspension %d: %s", suspension.ID, req.Note),
+		IP:      c.IP(),
+	})
+
+	return c.JSON(suspension)
+}
+
+// Suspensions returns the suspensions of a user, latest first, including
+// appeals and why they were lifted.
+func (mh *ModerationHandler) Suspensions(c *fiber.Ctx) error {
+	username := c.Query("username", "")
+	if username == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The 'username' parameter is required"})
+	}
+
+	var user models.User
+	if err := mh.DB.Where("username = ?", username).First(&user).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	history, err := moderation.History(mh.DB, user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not load suspensions"})
+	}
+
+	return c.JSON(history)
+}
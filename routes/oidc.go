@@ -0,0 +1,442 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/dto"
+	"github.com/kostya-zero/blogger/helpers"
+	"github.com/kostya-zero/blogger/invites"
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/oidc"
+	"github.com/kostya-zero/blogger/sessions"
+	"github.com/kostya-zero/blogger/validation"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const oidcStateTTL = 10 * time.Minute
+
+// Purposes of an authorization request sent to a provider.
+const (
+	oidcPurposeLogin  = "login"
+	oidcPurposeLink   = "link"
+	oidcPurposeReauth = "reauth"
+)
+
+type OIDCHandler struct {
+	*AuthHandler
+	Providers oidc.Providers
+}
+
+func NewOIDCHandler(auth *AuthHandler, providers oidc.Providers) *OIDCHandler {
+	return &OIDCHandler{AuthHandler: auth, Providers: providers}
+}
+
+// beginAuthorization records a new authorization request and returns the URL
+// of the provider to send the browser to. The state is also kept in a cookie
+// so the callback only accepts it from the browser that started the flow.
+func (h *OIDCHandler) beginAuthorization(c *fiber.Ctx, provider *oidc.Provider, purpose string, userID, sessionID *uint, prompt string) (string, error) {
+	state, err := helpers.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	nonce, err := helpers.RandomToken(16)
+	if err != nil {
+		return "", err
+	}
+
+	verifier, err := oidc.NewVerifier()
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := provider.AuthCodeURL(c.UserContext(), state, nonce, verifier, prompt)
+	if err != nil {
+		return "", err
+	}
+
+	err = h.DB.Create(&models.OIDCState{
+		StateHash: helpers.HashToken(state),
+		Provider:  provider.Name,
+		Purpose:   purpose,
+		Nonce:     nonce,
+		Verifier:  verifier,
+		UserID:    userID,
+		SessionID: sessionID,
+		ExpiresAt: time.Now().Add(oidcStateTTL),
+	}).Error
+	if err != nil {
+		return "", err
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     "oidc_state",
+		Value:    state,
+		Path:     "/auth/oidc",
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteLaxMode,
+		Expires:  time.Now().Add(oidcStateTTL),
+	})
+
+	return authURL, nil
+}
+
+// ListProviders lists the names of the providers users can sign in with.
+func (h *OIDCHandler) ListProviders(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"providers": h.Providers.Names()})
+}
+
+// Login redirects the browser to the provider to sign in.
+func (h *OIDCHandler) Login(c *fiber.Ctx) error {
+	provider, ok := h.Providers[c.Params("provider")]
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Unknown provider"})
+	}
+
+	authURL, err := h.beginAuthorization(c, provider, oidcPurposeLogin, nil, nil, "")
+	if err != nil {
+		fmt.Printf("Failed to start sign in with %s: %s\n", provider.Name, err.Error())
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Could not reach the provider"})
+	}
+
+	return c.Redirect(authURL, fiber.StatusFound)
+}
+
+// Callback finishes an authorization request started by Login, Link or
+// Reauthenticate once the provider sends the browser back.
+func (h *OIDCHandler) Callback(c *fiber.Ctx) error {
+	provider, ok := h.Providers[c.Params("provider")]
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Unknown provider"})
+	}
+
+	stateCookie := c.Cookies("oidc_state")
+	c.Cookie(&fiber.Cookie{Name: "oidc_state", Value: "", Path: "/auth/oidc", HTTPOnly: true, Expires: time.Now().Add(-1 * time.Hour)})
+
+	if c.Query("error") != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Sign in was cancelled or denied by the provider"})
+	}
+
+	raw := c.Query("state")
+	if raw == "" || raw != stateCookie {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired sign in request"})
+	}
+
+	var states []models.OIDCState
+	result := h.DB.Clauses(clause.Returning{}).
+		Where("state_hash = ? AND provider = ? AND expires_at > ?", helpers.HashToken(raw), provider.Name, time.Now()).
+		Delete(&states)
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+	}
+	if len(states) == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired sign in request"})
+	}
+	state := states[0]
+
+	rawIDToken, err := provider.Exchange(c.UserContext(), c.Query("code"), state.Verifier)
+	if err != nil {
+		fmt.Printf("Failed to exchange code with %s: %s\n", provider.Name, err.Error())
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Could not complete sign in with the provider"})
+	}
+
+	idToken, err := provider.Verify(c.UserContext(), rawIDToken, state.Nonce)
+	if err != nil {
+		fmt.Printf("Rejected ID token from %s: %s\n", provider.Name, err.Error())
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Could not verify the provider's response"})
+	}
+
+	switch state.Purpose {
+	case oidcPurposeLink:
+		return h.finishLink(c, provider, idToken, *state.UserID)
+	case oidcPurposeReauth:
+		return h.finishReauth(c, provider, idToken, *state.UserID, *state.SessionID)
+	default:
+		return h.finishLogin(c, provider, idToken)
+	}
+}
+
+func (h *OIDCHandler) finishLogin(c *fiber.Ctx, provider *oidc.Provider, idToken *oidc.IDToken) error {
+	var user models.User
+	var identity models.Identity
+	err := h.DB.Where("provider = ? AND subject = ?", provider.Name, idToken.Subject).First(&identity).Error
+	switch {
+	case err == nil:
+		if err := h.DB.First(&user, identity.UserID).Error; err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "User not found"})
+		}
+		h.DB.Model(&identity).Update("last_used_at", time.Now())
+
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if idToken.Email == "" || !idToken.EmailVerified {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The provider did not share a verified email address"})
+		}
+
+		// Taking over an existing account by email alone would let anyone
+		// who controls a provider account with that address in.
+		var count int64
+		h.DB.Model(&models.User{}).Where("email = ?", idToken.Email).Count(&count)
+		if count > 0 {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": "An account with this email already exists. Sign in and link the provider in settings",
+			})
+		}
+
+		var refused *invites.Error
+		if errors.As(h.Invites.Check(idToken.Email, false), &refused) {
+			return refuseRegistration(c, refused)
+		}
+
+		if err := h.createFromIdentity(provider, idToken, &user); err != nil {
+			if errors.Is(err, gorm.ErrDuplicatedKey) {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Could not create account, try again"})
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create user in database"})
+		}
+
+	default:
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+	}
+
+	amr := []string{sessions.MethodOIDC}
+	if h.requiresMFA(&user, amr) {
+		return h.startMFAChallenge(c, &user, amr)
+	}
+
+	return h.startSession(c, &user, "", "", amr)
+}
+
+// createFromIdentity registers a new user without a password for an identity
+// not seen before.
+func (h *OIDCHandler) createFromIdentity(provider *oidc.Provider, idToken *oidc.IDToken, user *models.User) error {
+	base := idToken.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(idToken.Email, "@")
+	}
+
+	username, err := availableUsername(h.DB, base)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	*user = models.User{
+		Username:   username,
+		Email:      idToken.Email,
+		CreatedAt:  now,
+		VerifiedAt: &now,
+	}
+	if idToken.Name != "" {
+		user.DisplayName = &idToken.Name
+	}
+
+	return h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.Identity{
+			UserID:     user.ID,
+			Provider:   provider.Name,
+			Subject:    idToken.Subject,
+			Email:      idToken.Email,
+			LastUsedAt: &now,
+		}).Error
+	})
+}
+
+// availableUsername derives a valid username from base, e.g. a provider's
+// profile or the local part of an email, adding a number when it is taken.
+func availableUsername(db *gorm.DB, base string) (string, error) {
+	var b strings.Builder
+	for _, r := range strings.ToLower(base) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_') {
+			b.WriteRune(r)
+		}
+	}
+	base = b.String()
+	if len(base) > 15 {
+		base = base[:15]
+	}
+	if len(base) < 3 {
+		base = "user"
+	}
+
+	candidate := base
+	for i := 1; i <= 100; i++ {
+		var count int64
+		if err := db.Model(&models.User{}).Where("username = ?", candidate).Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+		candidate = base + strconv.Itoa(i+1)
+	}
+
+	return "", gorm.ErrDuplicatedKey
+}
+
+func (h *OIDCHandler) finishLink(c *fiber.Ctx, provider *oidc.Provider, idToken *oidc.IDToken, userID uint) error {
+	var existing models.Identity
+	err := h.DB.Where("provider = ? AND subject = ?", provider.Name, idToken.Subject).First(&existing).Error
+	if err == nil {
+		if existing.UserID != userID {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "This account is already linked to another user"})
+		}
+		return c.JSON(fiber.Map{"success": 1})
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+	}
+
+	err = h.DB.Create(&models.Identity{
+		UserID:   userID,
+		Provider: provider.Name,
+		Subject:  idToken.Subject,
+		Email:    idToken.Email,
+	}).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "This account is already linked to another user"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not link account"})
+	}
+
+	return c.JSON(fiber.Map{"success": 1})
+}
+
+func (h *OIDCHandler) finishReauth(c *fiber.Ctx, provider *oidc.Provider, idToken *oidc.IDToken, userID, sessionID uint) error {
+	var count int64
+	h.DB.Model(&models.Identity{}).
+		Where("user_id = ? AND provider = ? AND subject = ?", userID, provider.Name, idToken.Subject).
+		Count(&count)
+	if count == 0 {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "This account is not linked to you"})
+	}
+
+	return h.issueSudo(c, userID, sessionID, []string{sessions.MethodOIDC})
+}
+
+// Reauthenticate is the counterpart of AuthHandler.Reauthenticate for users
+// who sign in through a provider. It returns the URL to send the browser to.
+func (h *OIDCHandler) Reauthenticate(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var req dto.OIDCReauthenticateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
+	}
+
+	provider, ok := h.Providers[req.Provider]
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Unknown provider"})
+	}
+
+	var count int64
+	h.DB.Model(&models.Identity{}).Where("user_id = ? AND provider = ?", claims.UserID, provider.Name).Count(&count)
+	if count == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "No account of this provider is linked"})
+	}
+
+	authURL, err := h.beginAuthorization(c, provider, oidcPurposeReauth, &claims.UserID, &claims.SessionID, "login")
+	if err != nil {
+		fmt.Printf("Failed to start reauthentication with %s: %s\n", provider.Name, err.Error())
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Could not reach the provider"})
+	}
+
+	return c.JSON(fiber.Map{"url": authURL})
+}
+
+// Link starts linking an account at a provider to the signed-in user and
+// returns the URL to send the browser to.
+func (h *OIDCHandler) Link(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var req dto.LinkIdentityRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
+	}
+
+	provider, ok := h.Providers[req.Provider]
+	if !ok {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Unknown provider"})
+	}
+
+	var user models.User
+	if err := h.DB.First(&user, claims.UserID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	if ok, err := checkSudo(c, h.DB, h.Keys, h.Lockout, claims, &user, req.CurrentPassword); !ok {
+		return err
+	}
+
+	authURL, err := h.beginAuthorization(c, provider, oidcPurposeLink, &claims.UserID, &claims.SessionID, "")
+	if err != nil {
+		fmt.Printf("Failed to start linking with %s: %s\n", provider.Name, err.Error())
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "Could not reach the provider"})
+	}
+
+	return c.JSON(fiber.Map{"url": authURL})
+}
+
+func (h *OIDCHandler) Identities(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var identities []models.Identity
+	if err := h.DB.Where("user_id = ?", claims.UserID).Order("created_at").Find(&identities).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not retrieve linked accounts"})
+	}
+
+	return c.JSON(identities)
+}
+
+// Unlink removes a linked identity unless it is the only way left to sign in.
+func (h *OIDCHandler) Unlink(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	identityID, err := strconv.ParseUint(c.Query("id", ""), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The 'id' parameter is required"})
+	}
+
+	err = removeSignInMethod(h.DB, claims.UserID, &models.Identity{}, uint(identityID))
+	if err != nil {
+		switch {
+		case errors.Is(err, errLastSignInMethod):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Set a password before unlinking your only sign-in method"})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Linked account not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not unlink account"})
+	}
+
+	return c.JSON(fiber.Map{"success": 1})
+}
@@ -0,0 +1,256 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/oidc"
+	"github.com/kostya-zero/blogger/oidc/oidctest"
+)
+
+type oidcTest struct {
+	h        *OIDCHandler
+	app      *fiber.App
+	provider *oidctest.Server
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	t.Helper()
+
+	provider, err := oidctest.Start("blogger")
+	if err != nil {
+		t.Fatalf("start provider: %v", err)
+	}
+	t.Cleanup(provider.Close)
+
+	cfg := provider.Config("mock", testPublicURL+"/auth/oidc/mock/callback")
+	h := NewOIDCHandler(newTestAuthHandler(t), oidc.Providers{"mock": oidc.NewProvider(cfg, nil)})
+
+	app := fiber.New()
+	app.Get("/auth/oidc/:provider", h.Login)
+	app.Get("/auth/oidc/:provider/callback", h.Callback)
+
+	return &oidcTest{h: h, app: app, provider: provider}
+}
+
+// authorize follows the redirect to the provider, which signs its user in at
+// once, and returns the callback URL it sends the browser back to.
+func (o *oidcTest) authorize(t *testing.T, authURL string) *url.URL {
+	t.Helper()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("authorize: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorize: status %d", resp.StatusCode)
+	}
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("callback URL: %v", err)
+	}
+	return callback
+}
+
+// start begins a sign in and returns the callback URL and the state cookie.
+func (o *oidcTest) start(t *testing.T) (*url.URL, *http.Cookie) {
+	t.Helper()
+
+	resp, err := o.app.Test(httptest.NewRequest(http.MethodGet, "/auth/oidc/mock", nil))
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("login: status %d", resp.StatusCode)
+	}
+
+	authURL := resp.Header.Get("Location")
+	query, _ := url.Parse(authURL)
+	if query.Query().Get("code_challenge_method") != "S256" || query.Query().Get("code_challenge") == "" {
+		t.Fatalf("authorization request without PKCE: %s", authURL)
+	}
+
+	var cookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == "oidc_state" {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		t.Fatal("no state cookie")
+	}
+
+	return o.authorize(t, authURL), cookie
+}
+
+// callback sends the browser back to Blogger with the state cookie.
+func (o *oidcTest) callback(t *testing.T, callback *url.URL, cookie *http.Cookie) *http.Response {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+
+	resp, err := o.app.Test(req)
+	if err != nil {
+		t.Fatalf("callback: %v", err)
+	}
+	return resp
+}
+
+func TestOIDCLoginCreatesAccount(t *testing.T) {
+	o := newOIDCTest(t)
+	o.provider.SetUser(oidctest.User{Subject: "42", Email: "new@example.com", EmailVerified: true, PreferredUsername: "newbie"})
+
+	callback, cookie := o.start(t)
+	resp := o.callback(t, callback, cookie)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("callback: status %d: %v", resp.StatusCode, decode(t, resp))
+	}
+
+	var identity models.Identity
+	if err := o.h.DB.Where("provider = ? AND subject = ?", "mock", "42").First(&identity).Error; err != nil {
+		t.Fatalf("identity not stored: %v", err)
+	}
+
+	var user models.User
+	o.h.DB.First(&user, identity.UserID)
+	if user.Email != "new@example.com" || user.Username != "newbie" || user.VerifiedAt == nil {
+		t.Errorf("unexpected user %+v", user)
+	}
+
+	// The state is single use.
+	if resp := o.callback(t, callback, cookie); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("replayed callback: status %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestOIDCStateMismatch(t *testing.T) {
+	o := newOIDCTest(t)
+
+	callback, cookie := o.start(t)
+
+	if resp := o.callback(t, callback, nil); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("without cookie: status %d, want 400", resp.StatusCode)
+	}
+
+	forged := *cookie
+	forged.Value = "not-the-state"
+	if resp := o.callback(t, callback, &forged); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("other cookie: status %d, want 400", resp.StatusCode)
+	}
+
+	tampered := *callback
+	q := tampered.Query()
+	q.Set("state", "not-the-state")
+	tampered.RawQuery = q.Encode()
+	if resp := o.callback(t, &tampered, cookie); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("other state: status %d, want 400", resp.StatusCode)
+	}
+
+	var count int64
+	o.h.DB.Model(&models.User{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d users created", count)
+	}
+}
+
+func TestOIDCNonceMismatch(t *testing.T) {
+	o := newOIDCTest(t)
+
+	callback, cookie := o.start(t)
+	// The provider puts the nonce of the authorization request in the ID
+	// token, which no longer matches the one Blogger expects.
+	o.h.DB.Model(&models.OIDCState{}).Where("1 = 1").Update("nonce", "other-nonce")
+
+	resp := o.callback(t, callback, cookie)
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("status %d, want 401", resp.StatusCode)
+	}
+}
+
+func TestOIDCWrongCodeVerifier(t *testing.T) {
+	o := newOIDCTest(t)
+
+	callback, cookie := o.start(t)
+	verifier, _ := oidc.NewVerifier()
+	o.h.DB.Model(&models.OIDCState{}).Where("1 = 1").Update("verifier", verifier)
+
+	resp := o.callback(t, callback, cookie)
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("status %d, want 502", resp.StatusCode)
+	}
+
+	var count int64
+	o.h.DB.Model(&models.Identity{}).Count(&count)
+	if count != 0 {
+		t.Errorf("%d identities created", count)
+	}
+}
+
+func TestOIDCExistingEmailNeedsLinking(t *testing.T) {
+	o := newOIDCTest(t)
+	user := createTestUser(t, o.h.AuthHandler, "reader", "reader@example.com")
+	o.provider.SetUser(oidctest.User{Subject: "7", Email: "reader@example.com", EmailVerified: true})
+
+	// Signing in by a verified email alone must not take over the account.
+	callback, cookie := o.start(t)
+	resp := o.callback(t, callback, cookie)
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("sign in: status %d, want 409", resp.StatusCode)
+	}
+
+	// The owner links the provider from their account instead.
+	o.app.Post("/settings/identities/link", signedIn(user.ID, 0), o.h.Link)
+	req := httptest.NewRequest(http.MethodPost, "/settings/identities/link",
+		strings.NewReader(`{"provider":"mock","current_password":"correct horse"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := o.app.Test(req)
+	if err != nil {
+		t.Fatalf("link: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("link: status %d: %v", resp.StatusCode, decode(t, resp))
+	}
+
+	var linkCookie *http.Cookie
+	for _, c := range resp.Cookies() {
+		if c.Name == "oidc_state" {
+			linkCookie = c
+		}
+	}
+	authURL, _ := decode(t, resp)["url"].(string)
+	if resp := o.callback(t, o.authorize(t, authURL), linkCookie); resp.StatusCode != http.StatusOK {
+		t.Fatalf("link callback: status %d: %v", resp.StatusCode, decode(t, resp))
+	}
+
+	var identity models.Identity
+	if err := o.h.DB.Where("provider = ? AND subject = ?", "mock", "7").First(&identity).Error; err != nil {
+		t.Fatalf("identity not linked: %v", err)
+	}
+	if identity.UserID != user.ID {
+		t.Fatalf("identity linked to user %d, want %d", identity.UserID, user.ID)
+	}
+
+	// Now the provider signs the owner in.
+	callback, cookie = o.start(t)
+	if resp := o.callback(t, callback, cookie); resp.StatusCode != http.StatusOK {
+		t.Fatalf("sign in after linking: status %d: %v", resp.StatusCode, decode(t, resp))
+	}
+
+	var count int64
+	o.h.DB.Model(&models.User{}).Count(&count)
+	if count != 1 {
+		t.Errorf("%d users, want 1", count)
+	}
+}
@@ -0,0 +1,323 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/dto"
+	"github.com/kostya-zero/blogger/helpers"
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/passkeys"
+	"github.com/kostya-zero/blogger/sessions"
+	"github.com/kostya-zero/blogger/validation"
+	"gorm.io/gorm"
+)
+
+const maxPasskeys = 10
+
+type PasskeysHandler struct {
+	*AuthHandler
+	Passkeys *passkeys.Store
+}
+
+func NewPasskeysHandler(auth *AuthHandler, store *passkeys.Store) *PasskeysHandler {
+	return &PasskeysHandler{AuthHandler: auth, Passkeys: store}
+}
+
+// passkeyAMR returns the authentication methods of a passkey assertion. With
+// user verification the authenticator checked a PIN or biometric, so the
+// assertion alone is multi-factor.
+func passkeyAMR(assertion *passkeys.Assertion) []string {
+	amr := []string{sessions.MethodHardware}
+	if assertion.UserVerified {
+		amr = append(amr, sessions.MethodMFA)
+	}
+	return amr
+}
+
+func passkeyFailed(c *fiber.Ctx, err error) error {
+	if errors.Is(err, passkeys.ErrCeremonyNotFound) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Passkey request expired, please try again"})
+	}
+	if errors.Is(err, passkeys.ErrCloned) {
+		fmt.Printf("Rejected passkey assertion: %s\n", err.Error())
+	}
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid passkey"})
+}
+
+func ceremonyResponse(c *fiber.Ctx, handle string, options any) error {
+	return c.JSON(fiber.Map{
+		"ceremony_id": handle,
+		"options":     options,
+	})
+}
+
+// BeginLogin returns the options for navigator.credentials.get to sign in
+// with any passkey registered here, without asking for an email first.
+func (h *PasskeysHandler) BeginLogin(c *fiber.Ctx) error {
+	assertion, handle, err := h.Passkeys.BeginLogin(nil)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not start passkey login"})
+	}
+
+	return ceremonyResponse(c, handle, assertion)
+}
+
+func (h *PasskeysHandler) FinishLogin(c *fiber.Ctx) error {
+	var req dto.PasskeyLoginRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
+	}
+
+	assertion, err := h.Passkeys.FinishLogin(req.CeremonyID, passkeys.CeremonyLogin, req.Credential)
+	if err != nil {
+		return passkeyFailed(c, err)
+	}
+
+	user := assertion.User
+	amr := passkeyAMR(assertion)
+
+	// An authenticator that only checked presence is something the user
+	// has; with another factor set up they still have to prove it.
+	if !assertion.UserVerified && h.requiresMFA(&user, amr) {
+		return h.startMFAChallenge(c, &user, amr)
+	}
+
+	return h.startSession(c, &user, req.Device, req.TokenMode, amr)
+}
+
+// BeginMFA returns the options for navigator.credentials.get to answer an MFA
+// challenge with one of the user's passkeys.
+func (h *PasskeysHandler) BeginMFA(c *fiber.Ctx) error {
+	var req dto.PasskeyMFABeginRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
+	}
+
+	user, claims, err := h.mfaUser(req.MFAToken)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired MFA token"})
+	}
+
+	if slices.Contains(claims.AMR, sessions.MethodHardware) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Use your authenticator app or a recovery code"})
+	}
+
+	assertion, handle, err := h.Passkeys.BeginLogin(user)
+	if err != nil {
+		if errors.Is(err, passkeys.ErrNoPasskeys) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "No passkeys are registered"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not start passkey check"})
+	}
+
+	return ceremonyResponse(c, handle, assertion)
+}
+
+func (h *PasskeysHandler) FinishMFA(c *fiber.Ctx) error {
+	var req dto.PasskeyMFARequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
+	}
+
+	user, claims, err := h.mfaUser(req.MFAToken)
+	if err != nil || slices.Contains(claims.AMR, sessions.MethodHardware) {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired MFA token"})
+	}
+
+	account := accountKey(user.Email)
+	if locked, err := lockedOut(c, h.Lockout, account); locked {
+		return err
+	}
+
+	assertion, err := h.Passkeys.FinishLogin(req.CeremonyID, passkeys.CeremonyAssert, req.Credential)
+	if err == nil && assertion.User.ID != user.ID {
+		err = passkeys.ErrUnknownPasskey
+	}
+	if err != nil {
+		if !errors.Is(err, passkeys.ErrCeremonyNotFound) {
+			loginFailed(c, h.DB, h.Lockout, account, &user.ID)
+		}
+		return passkeyFailed(c, err)
+	}
+
+	loginSucceeded(h.Lockout, account)
+	amr := append(claims.AMR, sessions.MethodHardware, sessions.MethodMFA)
+	return h.startSession(c, user, req.Device, req.TokenMode, amr)
+}
+
+// BeginReauthenticate is the counterpart of AuthHandler.Reauthenticate for a
+// passkey of the signed-in user.
+func (h *PasskeysHandler) BeginReauthenticate(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var user models.User
+	if err := h.DB.First(&user, claims.UserID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	assertion, handle, err := h.Passkeys.BeginLogin(&user)
+	if err != nil {
+		if errors.Is(err, passkeys.ErrNoPasskeys) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "No passkeys are registered"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not start passkey check"})
+	}
+
+	return ceremonyResponse(c, handle, assertion)
+}
+
+func (h *PasskeysHandler) FinishReauthenticate(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var req dto.PasskeyAssertionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
+	}
+
+	assertion, err := h.Passkeys.FinishLogin(req.CeremonyID, passkeys.CeremonyAssert, req.Credential)
+	if err != nil {
+		return passkeyFailed(c, err)
+	}
+	if assertion.User.ID != claims.UserID {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "This passkey is not yours"})
+	}
+
+	return h.issueSudo(c, claims.UserID, claims.SessionID, passkeyAMR(assertion))
+}
+
+func (h *PasskeysHandler) List(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	list, err := h.Passkeys.List(claims.UserID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not load passkeys"})
+	}
+
+	return c.JSON(list)
+}
+
+// BeginRegistration returns the options for navigator.credentials.create to
+// add a passkey to the signed-in user.
+func (h *PasskeysHandler) BeginRegistration(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var req dto.BeginPasskeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	var user models.User
+	if err := h.DB.First(&user, claims.UserID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	if ok, err := checkSudo(c, h.DB, h.Keys, h.Lockout, claims, &user, req.CurrentPassword); !ok {
+		return err
+	}
+
+	count, err := h.Passkeys.Count(user.ID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not load passkeys"})
+	}
+	if count >= maxPasskeys {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": fmt.Sprintf("You can register at most %d passkeys", maxPasskeys)})
+	}
+
+	creation, handle, err := h.Passkeys.BeginRegistration(&user)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not start passkey registration"})
+	}
+
+	return ceremonyResponse(c, handle, creation)
+}
+
+func (h *PasskeysHandler) FinishRegistration(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var req dto.FinishPasskeyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
+	}
+
+	var user models.User
+	if err := h.DB.First(&user, claims.UserID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	passkey, err := h.Passkeys.FinishRegistration(&user, req.CeremonyID, req.Name, req.Credential)
+	if err != nil {
+		switch {
+		case errors.Is(err, passkeys.ErrCeremonyNotFound):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Passkey request expired, please try again"})
+		case errors.Is(err, gorm.ErrDuplicatedKey):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "This passkey is already registered"})
+		}
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid passkey"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(passkey)
+}
+
+func (h *PasskeysHandler) Remove(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	passkeyID, err := strconv.ParseUint(c.Query("id", ""), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The 'id' parameter is required"})
+	}
+
+	err = removeSignInMethod(h.DB, claims.UserID, &models.Passkey{}, uint(passkeyID))
+	if err != nil {
+		switch {
+		case errors.Is(err, errLastSignInMethod):
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Set a password before removing your only passkey"})
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Passkey not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not remove passkey"})
+	}
+
+	return c.JSON(fiber.Map{"success": 1})
+}
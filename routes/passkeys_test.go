@@ -0,0 +1,169 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/passkeys"
+	"github.com/kostya-zero/blogger/passkeys/softauthn"
+)
+
+type passkeyTest struct {
+	h    *PasskeysHandler
+	app  *fiber.App
+	auth *softauthn.Authenticator
+	user *models.User
+}
+
+// newPasskeyTest returns a user with a password and a passkey, but no TOTP.
+func newPasskeyTest(t *testing.T) *passkeyTest {
+	t.Helper()
+
+	auth := newTestAuthHandler(t)
+	store, err := passkeys.NewStore(auth.DB, "blogger.test", "Blogger", []string{testPublicURL})
+	if err != nil {
+		t.Fatalf("passkey store: %v", err)
+	}
+	h := NewPasskeysHandler(auth, store)
+
+	app := fiber.New()
+	app.Post("/auth/login", h.Login)
+	app.Post("/auth/login/mfa", h.LoginMFA)
+	app.Post("/auth/passkey/mfa/begin", h.BeginMFA)
+	app.Post("/auth/passkey/mfa", h.FinishMFA)
+
+	pt := &passkeyTest{
+		h:    h,
+		app:  app,
+		auth: softauthn.New(testPublicURL),
+		user: createTestUser(t, auth, "reader", "reader@example.com"),
+	}
+
+	options, handle, err := store.BeginRegistration(pt.user)
+	if err != nil {
+		t.Fatalf("begin registration: %v", err)
+	}
+	response, err := pt.auth.Register(options)
+	if err != nil {
+		t.Fatalf("authenticator register: %v", err)
+	}
+	if _, err := store.FinishRegistration(pt.user, handle, "laptop", response); err != nil {
+		t.Fatalf("finish registration: %v", err)
+	}
+
+	return pt
+}
+
+func (pt *passkeyTest) post(t *testing.T, path string, body any) (int, map[string]any) {
+	t.Helper()
+	return postJSON(t, pt.app, path, body)
+}
+
+// login signs in with the password and returns the MFA token it asks for.
+func (pt *passkeyTest) login(t *testing.T) string {
+	t.Helper()
+
+	status, body := pt.post(t, "/auth/login", map[string]any{
+		"email":    pt.user.Email,
+		"password": "correct horse",
+	})
+	if status != http.StatusOK || body["mfa_required"] != true {
+		t.Fatalf("login = %d %v, want an MFA challenge", status, body)
+	}
+	return body["mfa_token"].(string)
+}
+
+// begin starts a passkey check for the MFA token and returns the ceremony
+// handle and its options.
+func (pt *passkeyTest) begin(t *testing.T, mfaToken string) (string, *protocol.CredentialAssertion) {
+	t.Helper()
+
+	status, body := pt.post(t, "/auth/passkey/mfa/begin", map[string]any{"mfa_token": mfaToken})
+	if status != http.StatusOK {
+		t.Fatalf("begin = %d %v", status, body)
+	}
+
+	raw, err := json.Marshal(body["options"])
+	if err != nil {
+		t.Fatalf("encode options: %v", err)
+	}
+	var options protocol.CredentialAssertion
+	if err := json.Unmarshal(raw, &options); err != nil {
+		t.Fatalf("decode options: %v", err)
+	}
+	return body["ceremony_id"].(string), &options
+}
+
+func (pt *passkeyTest) finish(t *testing.T, mfaToken, ceremonyID string, credential json.RawMessage) (int, map[string]any) {
+	t.Helper()
+
+	return pt.post(t, "/auth/passkey/mfa", map[string]any{
+		"mfa_token":   mfaToken,
+		"ceremony_id": ceremonyID,
+		"credential":  credential,
+		"token_mode":  "body",
+	})
+}
+
+func TestPasskeyIsSecondFactor(t *testing.T) {
+	pt := newPasskeyTest(t)
+
+	status, body := pt.post(t, "/auth/login", map[string]any{
+		"email":    pt.user.Email,
+		"password": "correct horse",
+	})
+	if status != http.StatusOK || body["mfa_required"] != true {
+		t.Fatalf("login = %d %v, want an MFA challenge", status, body)
+	}
+	methods, _ := body["mfa_methods"].([]any)
+	if !slices.Equal(methods, []any{"passkey"}) {
+		t.Fatalf("mfa_methods = %v, want [passkey]", methods)
+	}
+	mfaToken := body["mfa_token"].(string)
+
+	// Without TOTP a code can't finish the login.
+	status, _ = pt.post(t, "/auth/login/mfa", map[string]any{"mfa_token": mfaToken, "code": "123456"})
+	if status != http.StatusBadRequest {
+		t.Fatalf("code login = %d, want %d", status, http.StatusBadRequest)
+	}
+
+	ceremonyID, options := pt.begin(t, mfaToken)
+	credential, err := pt.auth.Login(options)
+	if err != nil {
+		t.Fatalf("authenticator login: %v", err)
+	}
+
+	status, body = pt.finish(t, mfaToken, ceremonyID, credential)
+	if status != http.StatusOK || body["access_token"] == nil {
+		t.Fatalf("finish = %d %v, want tokens", status, body)
+	}
+}
+
+func TestPasskeyMFALockout(t *testing.T) {
+	pt := newPasskeyTest(t)
+	mfaToken := pt.login(t)
+
+	for range pt.h.Lockout.Accounts.Threshold {
+		ceremonyID, _ := pt.begin(t, mfaToken)
+		status, _ := pt.finish(t, mfaToken, ceremonyID, json.RawMessage(`{"id":"forged","type":"public-key"}`))
+		if status != http.StatusUnauthorized {
+			t.Fatalf("forged passkey = %d, want %d", status, http.StatusUnauthorized)
+		}
+	}
+
+	ceremonyID, options := pt.begin(t, mfaToken)
+	credential, err := pt.auth.Login(options)
+	if err != nil {
+		t.Fatalf("authenticator login: %v", err)
+	}
+
+	status, body := pt.finish(t, mfaToken, ceremonyID, credential)
+	if status != http.StatusTooManyRequests {
+		t.Fatalf("finish after lockout = %d %v, want %d", status, body, http.StatusTooManyRequests)
+	}
+}
@@ -0,0 +1,134 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/dto"
+	"github.com/kostya-zero/blogger/mailer"
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/onetime"
+	"github.com/kostya-zero/blogger/password"
+	"github.com/kostya-zero/blogger/validation"
+	"gorm.io/gorm"
+)
+
+const resetTTL = time.Hour
+
+func (h *AuthHandler) sendPasswordReset(user *models.User) error {
+	// Silently drop repeated requests so the endpoint can't be used to flood
+	// someone's inbox.
+	recent, err := h.Tokens.IssuedSince(user.ID, onetime.PurposeResetPassword, time.Now().Add(-resendInterval))
+	if err != nil {
+		return err
+	}
+	if recent > 0 {
+		return nil
+	}
+
+	token, err := h.Tokens.Issue(user.ID, onetime.PurposeResetPassword, user.Email, resetTTL)
+	if err != nil {
+		return err
+	}
+
+	return h.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nsomeone asked to reset the password of your Blogger account. If it was you, open this link:\n\n%s?token=%s\n\nThe link expires in one hour. If you did not ask for it, ignore this email.\n",
+			user.Username, h.PasswordResetURL, token),
+	})
+}
+
+func (h *AuthHandler) ForgotPassword(c *fiber.Ctx) error {
+	var req dto.ForgotPasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
+	}
+
+	// The lookup and the email happen in the background so that neither the
+	// response nor its timing reveals whether the address is registered. The
+	// parsed body may point into the request buffer, which Fiber reuses.
+	email := strings.Clone(req.Email)
+	queued := h.Background.Submit(func() error {
+		var user models.User
+		if err := h.DB.Where("email = ?", email).First(&user).Error; err != nil {
+			return nil
+		}
+
+		if err := h.sendPasswordReset(&user); err != nil {
+			return fmt.Errorf("password reset for user %d: %w", user.ID, err)
+		}
+		return nil
+	})
+	if !queued {
+		fmt.Println("Email queue is full, dropped a password reset request")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": 1,
+		"message": "If an account with this email exists, a password reset link was sent to it.",
+	})
+}
+
+func (h *AuthHandler) ResetPassword(c *fiber.Ctx) error {
+	var req dto.ResetPasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
+	}
+
+	// The link stays usable until a password that passes the policy is set.
+	token, err := h.Tokens.Lookup(req.Token, onetime.PurposeResetPassword)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired reset link"})
+	}
+
+	var user models.User
+	if err := h.DB.Where("id = ? AND email = ?", token.UserID, token.Email).First(&user).Error; err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired reset link"})
+	}
+
+	if err := password.Check(req.Password, user.Username, user.Email); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if _, err := h.Tokens.ConsumeTx(tx, req.Token, onetime.PurposeResetPassword); err != nil {
+			return err
+		}
+
+		hash, err := password.Hash(req.Password)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&user).Update("password_hash", hash).Error; err != nil {
+			return err
+		}
+
+		return h.Tokens.InvalidateTx(tx, user.ID, onetime.PurposeResetPassword)
+	})
+	if errors.Is(err, onetime.ErrInvalidToken) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired reset link"})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update password"})
+	}
+
+	// Whoever knew the old password is signed out everywhere.
+	if err := h.Sessions.RevokeUser(user.ID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to revoke sessions"})
+	}
+
+	clearAuthCookies(c)
+	return c.JSON(fiber.Map{"success": 1})
+}
@@ -0,0 +1,354 @@
+package routes
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/diff"
+	"github.com/kostya-zero/blogger/dto"
+	"github.com/kostya-zero/blogger/helpers"
+	"github.com/kostya-zero/blogger/jwt"
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/roles"
+	"github.com/kostya-zero/blogger/slugs"
+	"github.com/kostya-zero/blogger/validation"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errNotAuthor = errors.New("not the author of the post")
+	errUnchanged = errors.New("post is unchanged")
+)
+
+// baseRevision is the first version of a post. Posts from before revisions
+// were kept have it only implicitly until they are first edited.
+func baseRevision(post *models.Post) models.PostRevision {
+	return models.PostRevision{
+		PostID:      post.ID,
+		Number:      1,
+		Title:       post.Title,
+		Description: post.Description,
+		Content:     post.Content,
+		EditorID:    post.UserID,
+		CreatedAt:   post.CreatedAt,
+	}
+}
+
+// editPost replaces the text of a post the author edits and records it as a
+// new revision. The caller must hold a lock on the post.
+func editPost(tx *gorm.DB, post *models.Post, editorID uint, title, description, content string, restoredFrom *int) (*models.PostRevision, error) {
+	if post.UserID != editorID {
+		return nil, errNotAuthor
+	}
+	if post.Title == title && post.Description == description && post.Content == content {
+		return nil, errUnchanged
+	}
+
+	var latest []models.PostRevision
+	if err := tx.Where("post_id = ?", post.ID).Order("number DESC").Limit(1).Find(&latest).Error; err != nil {
+		return nil, err
+	}
+	if len(latest) == 0 {
+		first := baseRevision(post)
+		if err := tx.Create(&first).Error; err != nil {
+			return nil, err
+		}
+		latest = append(latest, first)
+	}
+
+	now := time.Now()
+	revision := models.PostRevision{
+		PostID:       post.ID,
+		Number:       latest[0].Number + 1,
+		Title:        title,
+		Description:  description,
+		Content:      content,
+		EditorID:     editorID,
+		CreatedAt:    now,
+		RestoredFrom: restoredFrom,
+	}
+	if err := tx.Create(&revision).Error; err != nil {
+		return nil, err
+	}
+
+	changes := map[string]any{
+		"title":       title,
+		"description": description,
+		"content":     content,
+		"edited_at":   now,
+	}
+	if post.Title != title {
+		post.Title = title
+		if err := slugs.Assign(tx, post); err != nil {
+			return nil, err
+		}
+		changes["slug"] = post.Slug
+	}
+
+	err := tx.Model(post).Updates(changes).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &revision, nil
+}
+
+// findRevision returns the revision of the post with the number.
+func (ph *PostsHandler) findRevision(db *gorm.DB, post *models.Post, number int) (*models.PostRevision, error) {
+	var revisions []models.PostRevision
+	if err := db.Where("post_id = ? AND number = ?", post.ID, number).Limit(1).Find(&revisions).Error; err != nil {
+		return nil, err
+	}
+	if len(revisions) > 0 {
+		return &revisions[0], nil
+	}
+
+	if number == 1 && post.EditedAt == nil {
+		first := baseRevision(post)
+		return &first, nil
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// latestRevision returns the number of the current version of the post.
+func (ph *PostsHandler) latestRevision(post *models.Post) (int, error) {
+	var number *int
+	err := ph.DB.Model(&models.PostRevision{}).Select("MAX(number)").Where("post_id = ?", post.ID).Scan(&number).Error
+	if err != nil || number == nil {
+		return 1, err
+	}
+	return *number, nil
+}
+
+func postEditFailed(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Post not found."})
+	case errors.Is(err, errNotAuthor):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only the author can edit this post"})
+	case errors.Is(err, errUnchanged):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Nothing changed"})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not update post"})
+}
+
+func (ph *PostsHandler) UpdatePost(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	postID, err := strconv.ParseUint(c.Query("id", ""), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The 'id' parameter is required"})
+	}
+
+	var req dto.UpdatePostRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
+	}
+
+	var revision *models.PostRevision
+	err = ph.DB.Transaction(func(tx *gorm.DB) error {
+		var post models.Post
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&post, postID).Error; err != nil {
+			return err
+		}
+
+		revision, err = editPost(tx, &post, claims.UserID, req.Title, req.Description, req.Content, nil)
+		return err
+	})
+	if err != nil {
+		return postEditFailed(c, err)
+	}
+
+	return c.JSON(fiber.Map{"success": 1, "revision": revision.Number})
+}
+
+// historyPost loads the post whose history is asked for. Earlier revisions
+// may hold text the author took out, so only the author and moderators can
+// read them.
+func (ph *PostsHandler) historyPost(id string, claims *jwt.TokenClaims) (*models.Post, error) {
+	if claims.HasPermission(roles.PermModeratePosts) {
+		var post models.Post
+		if err := ph.DB.Where("id = ?", id).First(&post).Error; err != nil {
+			return nil, err
+		}
+		return &post, nil
+	}
+
+	post, err := ph.visiblePost(ph.DB, id, claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if post.UserID != claims.UserID {
+		return nil, errNotAuthor
+	}
+	return post, nil
+}
+
+func postHistoryFailed(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Post not found."})
+	case errors.Is(err, errNotAuthor):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only the author and moderators can see the history of this post"})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not load post"})
+}
+
+// Revisions lists the versions of a post, latest first, without their
+// content.
+func (ph *PostsHandler) Revisions(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	post, err := ph.historyPost(c.Query("id", ""), claims)
+	if err != nil {
+		return postHistoryFailed(c, err)
+	}
+
+	var revisions []models.PostRevision
+	err = ph.DB.Select("post_id", "number", "title", "description", "editor_id", "created_at", "restored_from").
+		Where("post_id = ?", post.ID).
+		Order("number DESC").
+		Find(&revisions).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not load revisions"})
+	}
+
+	if len(revisions) == 0 {
+		first := baseRevision(post)
+		first.Content = ""
+		revisions = append(revisions, first)
+	}
+
+	return c.JSON(revisions)
+}
+
+func (ph *PostsHandler) Revision(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	post, err := ph.historyPost(c.Query("id", ""), claims)
+	if err != nil {
+		return postHistoryFailed(c, err)
+	}
+
+	number, err := strconv.Atoi(c.Query("rev", ""))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The 'rev' parameter is required"})
+	}
+
+	revision, err := ph.findRevision(ph.DB, post, number)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Revision not found"})
+	}
+
+	return c.JSON(revision)
+}
+
+// Diff compares two revisions of a post line by line. 'to' defaults to the
+// current version.
+func (ph *PostsHandler) Diff(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	post, err := ph.historyPost(c.Query("id", ""), claims)
+	if err != nil {
+		return postHistoryFailed(c, err)
+	}
+
+	from, err := strconv.Atoi(c.Query("from", ""))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The 'from' parameter is required"})
+	}
+
+	to, err := strconv.Atoi(c.Query("to", "0"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Bad 'to' parameter"})
+	}
+	if to == 0 {
+		if to, err = ph.latestRevision(post); err != nil {
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not load revisions"})
+		}
+	}
+
+	older, err := ph.findRevision(ph.DB, post, from)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Revision not found"})
+	}
+	newer, err := ph.findRevision(ph.DB, post, to)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Revision not found"})
+	}
+
+	content := diff.Lines(older.Content, newer.Content)
+	deleted, inserted := diff.Count(content)
+
+	return c.JSON(fiber.Map{
+		"from":        from,
+		"to":          to,
+		"title":       diff.Lines(older.Title, newer.Title),
+		"description": diff.Lines(older.Description, newer.Description),
+		"content":     content,
+		"deleted":     deleted,
+		"inserted":    inserted,
+	})
+}
+
+// RestoreRevision makes an old revision the current version again, as a new
+// revision.
+func (ph *PostsHandler) RestoreRevision(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	postID, err := strconv.ParseUint(c.Query("id", ""), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The 'id' parameter is required"})
+	}
+
+	number, err := strconv.Atoi(c.Query("rev", ""))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The 'rev' parameter is required"})
+	}
+
+	var revision *models.PostRevision
+	err = ph.DB.Transaction(func(tx *gorm.DB) error {
+		var post models.Post
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&post, postID).Error; err != nil {
+			return err
+		}
+		if post.UserID != claims.UserID {
+			return errNotAuthor
+		}
+
+		old, err := ph.findRevision(tx, &post, number)
+		if err != nil {
+			return err
+		}
+
+		revision, err = editPost(tx, &post, claims.UserID, old.Title, old.Description, old.Content, &old.Number)
+		return err
+	})
+	if err != nil {
+		return postEditFailed(c, err)
+	}
+
+	return c.JSON(fiber.Map{"success": 1, "revision": revision.Number})
+}
@@ -0,0 +1,127 @@
+package routes
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/jwt"
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/publishing"
+	"github.com/kostya-zero/blogger/roles"
+	"gorm.io/gorm"
+)
+
+func TestPostHistoryAccess(t *testing.T) {
+	auth := newTestAuthHandler(t)
+	ph := NewPostsHandler(auth.DB, nil)
+	author := createTestUser(t, auth, "writer", "writer@example.com")
+	reader := createTestUser(t, auth, "reader", "reader@example.com")
+
+	now := time.Now()
+	post := models.Post{
+		UserID:      author.ID,
+		Title:       "Hello",
+		Description: "First post",
+		Content:     "Hello, world",
+		CreatedAt:   now,
+		Status:      publishing.StatusPublished,
+		PublishedAt: &now,
+	}
+	if err := auth.DB.Create(&post).Error; err != nil {
+		t.Fatalf("create post: %v", err)
+	}
+
+	moderator := jwt.NewClaims(reader.ID, 0, time.Minute)
+	moderator.Permissions = []string{roles.PermModeratePosts}
+
+	for _, tt := range []struct {
+		name   string
+		viewer *jwt.TokenClaims
+		want   int
+	}{
+		{"anonymous", nil, http.StatusUnauthorized},
+		{"other user", jwt.NewClaims(reader.ID, 0, time.Minute), http.StatusForbidden},
+		{"author", jwt.NewClaims(author.ID, 0, time.Minute), http.StatusOK},
+		{"moderator", moderator, http.StatusOK},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Use(func(c *fiber.Ctx) error {
+				if tt.viewer != nil {
+					c.Locals("user", tt.viewer)
+				}
+				return c.Next()
+			})
+			app.Get("/posts/revisions", ph.Revisions)
+			app.Get("/posts/revision", ph.Revision)
+			app.Get("/posts/diff", ph.Diff)
+
+			for _, path := range []string{
+				fmt.Sprintf("/posts/revisions?id=%d", post.ID),
+				fmt.Sprintf("/posts/revision?id=%d&rev=1", post.ID),
+				fmt.Sprintf("/posts/diff?id=%d&from=1", post.ID),
+			} {
+				resp, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil), -1)
+				if err != nil {
+					t.Fatalf("GET %s: %v", path, err)
+				}
+				if resp.StatusCode != tt.want {
+					t.Errorf("GET %s = %d, want %d", path, resp.StatusCode, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRestoreRevision(t *testing.T) {
+	auth := newTestAuthHandler(t)
+	ph := NewPostsHandler(auth.DB, nil)
+	author := createTestUser(t, auth, "writer", "writer@example.com")
+
+	post := models.Post{UserID: author.ID, Title: "Hello", Description: "First post", Content: "Hello, world", CreatedAt: time.Now()}
+	if err := auth.DB.Create(&post).Error; err != nil {
+		t.Fatalf("create post: %v", err)
+	}
+	for _, content := range []string{"Hello again", "Goodbye"} {
+		err := auth.DB.Transaction(func(tx *gorm.DB) error {
+			_, err := editPost(tx, &post, author.ID, post.Title, post.Description, content, nil)
+			return err
+		})
+		if err != nil {
+			t.Fatalf("edit post: %v", err)
+		}
+	}
+
+	app := fiber.New()
+	app.Post("/posts/restore", signedIn(author.ID, 0), ph.RestoreRevision)
+
+	status, body := postJSON(t, app, fmt.Sprintf("/posts/restore?id=%d&rev=1", post.ID), nil)
+	if status != http.StatusOK || body["revision"] != float64(4) {
+		t.Fatalf("restore = %d %v, want revision 4", status, body)
+	}
+
+	var revision models.PostRevision
+	if err := auth.DB.Where("post_id = ? AND number = ?", post.ID, 4).First(&revision).Error; err != nil {
+		t.Fatalf("load revision: %v", err)
+	}
+	if revision.RestoredFrom == nil || *revision.RestoredFrom != 1 || revision.Content != "Hello, world" {
+		t.Fatalf("revision 4 = %+v, want the content of revision 1 restored from it", revision)
+	}
+
+	var restored models.Post
+	if err := auth.DB.First(&restored, post.ID).Error; err != nil {
+		t.Fatalf("load post: %v", err)
+	}
+	if restored.Content != "Hello, world" {
+		t.Fatalf("content = %q, want Hello, world", restored.Content)
+	}
+
+	// Restoring the current version again changes nothing.
+	if status, body := postJSON(t, app, fmt.Sprintf("/posts/restore?id=%d&rev=4", post.ID), nil); status != http.StatusBadRequest {
+		t.Fatalf("restore current = %d %v, want %d", status, body, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,71 @@
+package routes
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/models"
+	"gorm.io/gorm"
+)
+
+// postURL is where a post is found by its slug.
+func postURL(username, slug string) string {
+	return "/@" + url.PathEscape(username) + "/" + url.PathEscape(slug)
+}
+
+// GetBySlug serves /@username/slug. A former username or a former slug of
+// the post redirects permanently to the current address.
+func (ph *PostsHandler) GetBySlug(c *fiber.Ctx) error {
+	username, err := url.PathUnescape(c.Params("username"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Post not found."})
+	}
+	slug, err := url.PathUnescape(c.Params("slug"))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Post not found."})
+	}
+
+	var user models.User
+	renamed := false
+	err = ph.DB.Select("id", "username").Where("username = ?", username).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		var old models.UsernameHistory
+		if err = ph.DB.Where("username = ?", username).First(&old).Error; err == nil {
+			err = ph.DB.Select("id", "username").First(&user, old.UserID).Error
+			renamed = true
+		}
+	}
+	if err != nil {
+		return slugLookupFailed(c, err)
+	}
+
+	viewer := viewerID(c)
+	var post models.Post
+	err = ph.DB.Preload("User").Scopes(ph.visible(viewer)).Where("user_id = ? AND slug = ?", user.ID, slug).First(&post).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		var old models.PostSlugHistory
+		if err = ph.DB.Where("user_id = ? AND slug = ?", user.ID, slug).First(&old).Error; err == nil {
+			var current *models.Post
+			if current, err = ph.visiblePost(ph.DB, old.PostID, viewer); err == nil {
+				return c.Redirect(postURL(user.Username, *current.Slug), fiber.StatusMovedPermanently)
+			}
+		}
+	}
+	if err != nil {
+		return slugLookupFailed(c, err)
+	}
+
+	if renamed {
+		return c.Redirect(postURL(user.Username, *post.Slug), fiber.StatusMovedPermanently)
+	}
+
+	return c.JSON(post)
+}
+
+func slugLookupFailed(c *fiber.Ctx, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Post not found."})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not load post"})
+}
@@ -0,0 +1,73 @@
+package routes
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/publishing"
+	"github.com/kostya-zero/blogger/slugs"
+	"gorm.io/gorm"
+)
+
+func TestGetBySlug(t *testing.T) {
+	auth := newTestAuthHandler(t)
+	ph := NewPostsHandler(auth.DB, nil)
+	user := createTestUser(t, auth, "writer", "writer@example.com")
+
+	now := time.Now()
+	post := models.Post{UserID: user.ID, Title: "First title", Description: "d", Content: "c", CreatedAt: now, Status: publishing.StatusPublished, PublishedAt: &now}
+	err := auth.DB.Transaction(func(tx *gorm.DB) error {
+		if err := slugs.Assign(tx, &post); err != nil {
+			return err
+		}
+		return tx.Create(&post).Error
+	})
+	if err != nil {
+		t.Fatalf("create post: %v", err)
+	}
+
+	// Retitle the post, then rename its author.
+	err = auth.DB.Transaction(func(tx *gorm.DB) error {
+		if _, err := editPost(tx, &post, user.ID, "Second title", post.Description, post.Content, nil); err != nil {
+			return err
+		}
+		if err := tx.Create(&models.UsernameHistory{UserID: user.ID, Username: "oldwriter"}).Error; err != nil {
+			return err
+		}
+		return tx.Model(user).Update("username", "newwriter").Error
+	})
+	if err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+
+	app := fiber.New()
+	app.Get("/@:username/:slug", ph.GetBySlug)
+
+	for _, tt := range []struct {
+		path     string
+		status   int
+		location string
+	}{
+		{"/@newwriter/second-title", http.StatusOK, ""},
+		{"/@newwriter/first-title", http.StatusMovedPermanently, "/@newwriter/second-title"},
+		{"/@oldwriter/second-title", http.StatusMovedPermanently, "/@newwriter/second-title"},
+		{"/@oldwriter/first-title", http.StatusMovedPermanently, "/@newwriter/second-title"},
+		{"/@newwriter/missing", http.StatusNotFound, ""},
+		{"/@nobody/second-title", http.StatusNotFound, ""},
+	} {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, tt.path, nil), -1)
+		if err != nil {
+			t.Fatalf("GET %s: %v", tt.path, err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("GET %s = %d, want %d", tt.path, resp.StatusCode, tt.status)
+		}
+		if location := resp.Header.Get(fiber.HeaderLocation); location != tt.location {
+			t.Errorf("GET %s redirects to %q, want %q", tt.path, location, tt.location)
+		}
+	}
+}
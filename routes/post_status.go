@@ -0,0 +1,107 @@
+package routes
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/dto"
+	"github.com/kostya-zero/blogger/helpers"
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/publishing"
+	"github.com/kostya-zero/blogger/validation"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+func postStatusFailed(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Post not found."})
+	case errors.Is(err, errNotAuthor):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only the author can change this post"})
+	case errors.Is(err, publishing.ErrPublishAtRequired), errors.Is(err, publishing.ErrUnknownStatus):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, publishing.ErrAlreadyPublished), errors.Is(err, publishing.ErrNotPublished):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not change post status"})
+}
+
+// SetStatus publishes, schedules, archives or unpublishes a post of the
+// author.
+func (ph *PostsHandler) SetStatus(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	postID, err := strconv.ParseUint(c.Query("id", ""), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The 'id' parameter is required"})
+	}
+
+	var req dto.SetPostStatusRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
+	}
+
+	var post models.Post
+	err = ph.DB.Transaction(func(tx *gorm.DB) error {
+		// The lock keeps the scheduler from publishing the post meanwhile.
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&post, postID).Error; err != nil {
+			return err
+		}
+		if post.UserID != claims.UserID {
+			return errNotAuthor
+		}
+
+		if err := publishing.Set(&post, req.Status, req.PublishAt, time.Now()); err != nil {
+			return err
+		}
+		return tx.Model(&post).Select("status", "published_at", "scheduled_at").Updates(&post).Error
+	})
+	if err != nil {
+		return postStatusFailed(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"success":      1,
+		"status":       post.Status,
+		"published_at": post.PublishedAt,
+		"scheduled_at": post.ScheduledAt,
+	})
+}
+
+// Drafts lists the posts of the user that are not published, newest first.
+// The 'status' parameter narrows it to drafts, scheduled or archived posts.
+func (ph *PostsHandler) Drafts(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	statuses := []string{publishing.StatusDraft, publishing.StatusScheduled, publishing.StatusArchived}
+	if status := c.Query("status", ""); status != "" {
+		if !slices.Contains(statuses, status) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Bad 'status' parameter"})
+		}
+		statuses = []string{status}
+	}
+
+	var posts []models.Post
+	err = ph.DB.Where("user_id = ? AND status IN ?", claims.UserID, statuses).
+		Order("created_at DESC").
+		Find(&posts).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not load drafts"})
+	}
+
+	return c.JSON(posts)
+}
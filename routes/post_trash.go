@@ -0,0 +1,96 @@
+package routes
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/helpers"
+	"github.com/kostya-zero/blogger/models"
+	"gorm.io/gorm"
+)
+
+// DeletePost moves a post of the author to their trash.
+func (ph *PostsHandler) DeletePost(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	postID, err := strconv.ParseUint(c.Query("id", ""), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The 'id' parameter is required"})
+	}
+
+	var post models.Post
+	if err := ph.DB.First(&post, postID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Post not found."})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not delete post"})
+	}
+
+	if post.UserID != claims.UserID {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Only the author can delete this post"})
+	}
+
+	if err := ph.DB.Delete(&post).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not delete post"})
+	}
+
+	return c.JSON(fiber.Map{"success": 1})
+}
+
+// Trash lists the deleted posts of the user, most recently deleted first,
+// with when each will be removed for good.
+func (ph *PostsHandler) Trash(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var posts []models.Post
+	err = ph.DB.Unscoped().Preload("User").
+		Where("user_id = ? AND deleted_at IS NOT NULL", claims.UserID).
+		Order("deleted_at DESC").
+		Find(&posts).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not load trash"})
+	}
+
+	trash := make([]fiber.Map, 0, len(posts))
+	for _, post := range posts {
+		trash = append(trash, fiber.Map{
+			"post":       post,
+			"deleted_at": post.DeletedAt.Time,
+			"purge_at":   ph.Purger.PurgeAt(post.DeletedAt.Time),
+		})
+	}
+
+	return c.JSON(trash)
+}
+
+// RestoreDeleted takes a post of the user out of the trash.
+func (ph *PostsHandler) RestoreDeleted(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	postID, err := strconv.ParseUint(c.Query("id", ""), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The 'id' parameter is required"})
+	}
+
+	result := ph.DB.Unscoped().Model(&models.Post{}).
+		Where("id = ? AND user_id = ? AND deleted_at IS NOT NULL", postID, claims.UserID).
+		Update("deleted_at", nil)
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not restore post"})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Post not found in trash"})
+	}
+
+	return c.JSON(fiber.Map{"success": 1})
+}
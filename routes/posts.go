@@ -9,16 +9,21 @@ import (
 	"github.com/kostya-zero/blogger/dto"
 	"github.com/kostya-zero/blogger/helpers"
 	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/moderation"
+	"github.com/kostya-zero/blogger/publishing"
+	"github.com/kostya-zero/blogger/slugs"
+	"github.com/kostya-zero/blogger/trash"
 	"github.com/kostya-zero/blogger/validation"
 	"gorm.io/gorm"
 )
 
 type PostsHandler struct {
-	DB *gorm.DB
+	DB     *gorm.DB
+	Purger *trash.Purger
 }
 
-func NewPostsHandler(db *gorm.DB) *PostsHandler {
-	return &PostsHandler{DB: db}
+func NewPostsHandler(db *gorm.DB, purger *trash.Purger) *PostsHandler {
+	return &PostsHandler{DB: db, Purger: purger}
 }
 
 func (ph *PostsHandler) CreatePost(c *fiber.Ctx) error {
@@ -38,19 +43,38 @@ func (ph *PostsHandler) CreatePost(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
 	}
 
+	status := req.Status
+	if status == "" {
+		status = publishing.StatusPublished
+	}
+
+	now := time.Now()
 	newPost := models.Post{
 		Title:       req.Title,
 		Description: req.Description,
 		Content:     req.Content,
 		UserID:      claims.UserID,
-		CreatedAt:   time.Now(),
+		CreatedAt:   now,
+	}
+	if err := publishing.Set(&newPost, status, req.PublishAt, now); err != nil {
+		return postStatusFailed(c, err)
 	}
 
-	if err := ph.DB.Create(&newPost).Error; err != nil {
+	err = ph.DB.Transaction(func(tx *gorm.DB) error {
+		if err := slugs.Assign(tx, &newPost); err != nil {
+			return err
+		}
+		if err := tx.Create(&newPost).Error; err != nil {
+			return err
+		}
+		first := baseRevision(&newPost)
+		return tx.Create(&first).Error
+	})
+	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create new post."})
 	}
 
-	return c.JSON(fiber.Map{"success": 1})
+	return c.JSON(fiber.Map{"success": 1, "id": newPost.ID, "slug": newPost.Slug, "status": newPost.Status})
 }
 
 func (ph *PostsHandler) GetPost(c *fiber.Ctx) error {
@@ -60,8 +84,8 @@ func (ph *PostsHandler) GetPost(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The 'id' parameter is required"})
 	}
 
-	var post models.Post
-	if err := ph.DB.Preload("User").Where("id = ?", postID).First(&post).Error; err != nil {
+	post, err := ph.visiblePost(ph.DB.Preload("User"), postID, viewerID(c))
+	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Post not found."})
 		}
@@ -71,6 +95,34 @@ func (ph *PostsHandler) GetPost(c *fiber.Ctx) error {
 	return c.JSON(post)
 }
 
+// viewerID returns the ID of the signed in user, or 0 for anonymous requests.
+func viewerID(c *fiber.Ctx) uint {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return 0
+	}
+	return claims.UserID
+}
+
+// visible limits a query to posts the viewer may open: posts of authors that
+// are not hidden, and of those only public ones unless the viewer wrote them.
+func (ph *PostsHandler) visible(viewerID uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("user_id NOT IN (?)", moderation.HiddenAuthors(ph.DB)).
+			Where("status IN ? OR user_id = ?", publishing.Public, viewerID)
+	}
+}
+
+// visiblePost loads a post the viewer may open.
+func (ph *PostsHandler) visiblePost(db *gorm.DB, id any, viewerID uint) (*models.Post, error) {
+	var post models.Post
+	err := db.Scopes(ph.visible(viewerID)).Where("id = ?", id).First(&post).Error
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
 func (ph *PostsHandler) Like(c *fiber.Ctx) error {
 	claims, err := helpers.GetClaimsFromContext(c)
 	if err != nil {
@@ -90,7 +142,9 @@ func (ph *PostsHandler) Like(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Bad post ID"})
 	}
 
-	if err = ph.DB.Where("id = ?", postIntID).First(&models.Post{}).Error; err != nil {
+	err = ph.DB.Where("id = ? AND status = ? AND user_id NOT IN (?)", postIntID, publishing.StatusPublished, moderation.HiddenAuthors(ph.DB)).
+		First(&models.Post{}).Error
+	if err != nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Post not found"})
 	}
 
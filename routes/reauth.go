@@ -0,0 +1,175 @@
+package routes
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/dto"
+	"github.com/kostya-zero/blogger/helpers"
+	"github.com/kostya-zero/blogger/jwt"
+	"github.com/kostya-zero/blogger/lockout"
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/password"
+	"github.com/kostya-zero/blogger/sessions"
+	"github.com/kostya-zero/blogger/validation"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// sudoTTL is how long a sudo token from /auth/reauthenticate allows sensitive
+// settings changes.
+const sudoTTL = 10 * time.Minute
+
+// Reauthenticate confirms the password (and second factor, if enabled) of the
+// signed-in user and issues a short-lived sudo token for the current session.
+func (h *AuthHandler) Reauthenticate(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var req dto.ReauthenticateRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
+	}
+
+	var user models.User
+	if err := h.DB.First(&user, claims.UserID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	account := accountKey(user.Email)
+	if locked, err := lockedOut(c, h.Lockout, account); locked {
+		return err
+	}
+
+	if ok, _, _ := password.Verify(req.Password, user.PasswordHash); !ok {
+		loginFailed(c, h.DB, h.Lockout, account, &user.ID)
+		return invalidCredentials(c)
+	}
+
+	amr := []string{sessions.MethodPassword}
+	if user.TOTPEnabledAt != nil {
+		if req.Code == "" {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Code is a required field"})
+		}
+
+		if err := useTOTPCode(h.DB, &user, req.Code); err != nil {
+			if errors.Is(err, errInvalidMFACode) {
+				loginFailed(c, h.DB, h.Lockout, account, &user.ID)
+				return invalidCredentials(c)
+			}
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not check code"})
+		}
+		amr = append(amr, sessions.MethodOTP, sessions.MethodMFA)
+	}
+
+	loginSucceeded(h.Lockout, account)
+	return h.issueSudo(c, user.ID, claims.SessionID, amr)
+}
+
+// issueSudo hands out a sudo token for the session as a cookie and in the
+// response body.
+func (h *AuthHandler) issueSudo(c *fiber.Ctx, userID, sessionID uint, amr []string) error {
+	sudo := jwt.NewClaims(userID, sessionID, sudoTTL)
+	sudo.Use = "sudo"
+	sudo.SetAuth(amr, time.Now())
+
+	token, err := jwt.SignToken(sudo, h.Keys)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not generate sudo token"})
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     "sudo_token",
+		Value:    token,
+		Path:     "/settings",
+		HTTPOnly: true,
+		SameSite: fiber.CookieSameSiteStrictMode,
+		Expires:  time.Now().Add(sudoTTL),
+	})
+
+	return c.JSON(fiber.Map{
+		"success":    1,
+		"sudo_token": token,
+		"expires_in": int(sudoTTL.Seconds()),
+	})
+}
+
+func (sh *SettingsHandler) confirmSudo(c *fiber.Ctx, claims *jwt.TokenClaims, user *models.User, currentPassword string) (bool, error) {
+	return checkSudo(c, sh.DB, sh.Keys, sh.Lockout, claims, user, currentPassword)
+}
+
+// checkSudo lets a sensitive settings change through if the request carries
+// the current password or a sudo token issued for this session. Otherwise it
+// writes the error response and returns false.
+func checkSudo(c *fiber.Ctx, db *gorm.DB, keys *jwt.KeyRing, guard *lockout.Guard, claims *jwt.TokenClaims, user *models.User, currentPassword string) (bool, error) {
+	if currentPassword != "" {
+		account := accountKey(user.Email)
+		if locked, err := lockedOut(c, guard, account); locked {
+			return false, err
+		}
+
+		if ok, _, _ := password.Verify(currentPassword, user.PasswordHash); !ok {
+			loginFailed(c, db, guard, account, &user.ID)
+			return false, c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Current password is wrong"})
+		}
+
+		return true, nil
+	}
+
+	token := c.Get("X-Sudo-Token")
+	if token == "" {
+		token = c.Cookies("sudo_token")
+	}
+
+	if token != "" {
+		sudo, err := jwt.ParseToken(token, keys)
+		if err == nil && sudo.Use == "sudo" && sudo.UserID == claims.UserID && sudo.SessionID == claims.SessionID {
+			return true, nil
+		}
+	}
+
+	return false, c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"error":  "Confirm your current password or reauthenticate to continue",
+		"reauth": "sudo",
+	})
+}
+
+var errLastSignInMethod = errors.New("last sign in method")
+
+// removeSignInMethod deletes a linked identity or passkey of the user unless
+// it is the only way left to sign in besides email links.
+func removeSignInMethod(db *gorm.DB, userID uint, model any, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return err
+		}
+
+		var identities, passkeys int64
+		if err := tx.Model(&models.Identity{}).Where("user_id = ?", user.ID).Count(&identities).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Passkey{}).Where("user_id = ?", user.ID).Count(&passkeys).Error; err != nil {
+			return err
+		}
+		if user.PasswordHash == "" && identities+passkeys <= 1 {
+			return errLastSignInMethod
+		}
+
+		result := tx.Where("id = ? AND user_id = ?", id, user.ID).Delete(model)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return nil
+	})
+}
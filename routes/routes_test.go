@@ -0,0 +1,107 @@
+package routes
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/dbtest"
+	"github.com/kostya-zero/blogger/invites"
+	"github.com/kostya-zero/blogger/jwt"
+	"github.com/kostya-zero/blogger/lockout"
+	"github.com/kostya-zero/blogger/mailer"
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/onetime"
+	"github.com/kostya-zero/blogger/password"
+	"github.com/kostya-zero/blogger/revocation"
+	"github.com/kostya-zero/blogger/sessions"
+)
+
+const testPublicURL = "http://blogger.test"
+
+// newTestAuthHandler returns an AuthHandler backed by a fresh database.
+func newTestAuthHandler(t *testing.T) *AuthHandler {
+	t.Helper()
+
+	db := dbtest.Open(t)
+	keys, err := jwt.NewKeyRing(jwt.NewDBKeyStore(db), jwt.AlgorithmEdDSA, "", time.Time{})
+	if err != nil {
+		t.Fatalf("key ring: %v", err)
+	}
+
+	inv, err := invites.NewStore(db, invites.ModeOpen, nil)
+	if err != nil {
+		t.Fatalf("invites: %v", err)
+	}
+
+	store := sessions.NewStore(db, time.Hour, revocation.NewMemoryStore(time.Minute))
+	guard := lockout.NewGuard(lockout.NewMemoryStore(time.Minute, time.Hour))
+	tokens := onetime.NewStore(db, []byte("test secret"))
+
+	return NewAuthHandler(db, keys, store, tokens, &mailer.MemoryOutbox{}, guard, inv, testPublicURL)
+}
+
+// createTestUser registers a verified user with the password "correct horse".
+func createTestUser(t *testing.T, h *AuthHandler, username, email string) *models.User {
+	t.Helper()
+
+	hash, err := password.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("hash password: %v", err)
+	}
+
+	now := time.Now()
+	user := models.User{Username: username, Email: email, PasswordHash: hash, CreatedAt: now, VerifiedAt: &now}
+	if err := h.DB.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return &user
+}
+
+// signedIn stands in for the auth middleware, authenticating every request as
+// the user.
+func signedIn(userID, sessionID uint) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals("user", jwt.NewClaims(userID, sessionID, time.Minute))
+		return c.Next()
+	}
+}
+
+// decode reads a JSON response body.
+func decode(t *testing.T, resp *http.Response) map[string]any {
+	t.Helper()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("read body: %v", err)
+	}
+
+	var v map[string]any
+	if err := json.Unmarshal(body, &v); err != nil {
+		t.Fatalf("decode %q: %v", body, err)
+	}
+	return v
+}
+
+// postJSON sends the body as JSON and decodes the JSON response.
+func postJSON(t *testing.T, app *fiber.App, path string, body any) (int, map[string]any) {
+	t.Helper()
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("encode body: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(payload))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("POST %s: %v", path, err)
+	}
+	return resp.StatusCode, decode(t, resp)
+}
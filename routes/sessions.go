@@ -0,0 +1,106 @@
+package routes
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/helpers"
+	"github.com/kostya-zero/blogger/sessions"
+	"gorm.io/gorm"
+)
+
+type SessionsHandler struct {
+	Sessions *sessions.Store
+}
+
+func NewSessionsHandler(store *sessions.Store) *SessionsHandler {
+	return &SessionsHandler{Sessions: store}
+}
+
+// deviceLabel returns the label the client asked for, or a rough guess based
+// on the user agent.
+func deviceLabel(requested, userAgent string) string {
+	if requested != "" {
+		return requested
+	}
+
+	platforms := []struct{ marker, name string }{
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Android", "Android"},
+		{"Windows", "Windows"},
+		{"Macintosh", "Mac"},
+		{"Linux", "Linux"},
+	}
+	for _, p := range platforms {
+		if strings.Contains(userAgent, p.marker) {
+			return p.name
+		}
+	}
+
+	return "Unknown device"
+}
+
+func (sh *SessionsHandler) List(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	list, err := sh.Sessions.List(claims.UserID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not retrieve sessions"})
+	}
+
+	result := make([]fiber.Map, 0, len(list))
+	for _, s := range list {
+		result = append(result, fiber.Map{
+			"id":           s.ID,
+			"device_label": s.DeviceLabel,
+			"user_agent":   s.UserAgent,
+			"ip":           s.IP,
+			"created_at":   s.CreatedAt,
+			"last_seen_at": s.LastSeenAt,
+			"expires_at":   s.ExpiresAt,
+			"current":      s.ID == claims.SessionID,
+		})
+	}
+
+	return c.JSON(result)
+}
+
+func (sh *SessionsHandler) Revoke(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	sessionID, err := strconv.ParseUint(c.Query("id", ""), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The 'id' parameter is required"})
+	}
+
+	if err := sh.Sessions.Revoke(claims.UserID, uint(sessionID)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Session not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not revoke session"})
+	}
+
+	return c.JSON(fiber.Map{"success": 1})
+}
+
+func (sh *SessionsHandler) RevokeOthers(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	if err := sh.Sessions.RevokeOthers(claims.UserID, claims.SessionID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not revoke sessions"})
+	}
+
+	return c.JSON(fiber.Map{"success": 1})
+}
@@ -1,21 +1,36 @@
 package routes
 
 import (
-	"fmt"
+	"errors"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/dto"
 	"github.com/kostya-zero/blogger/helpers"
+	"github.com/kostya-zero/blogger/jwt"
+	"github.com/kostya-zero/blogger/lockout"
+	"github.com/kostya-zero/blogger/mailer"
 	"github.com/kostya-zero/blogger/models"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/kostya-zero/blogger/onetime"
+	"github.com/kostya-zero/blogger/password"
+	"github.com/kostya-zero/blogger/sessions"
+	"github.com/kostya-zero/blogger/validation"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type SettingsHandler struct {
-	DB *gorm.DB
+	DB        *gorm.DB
+	Sessions  *sessions.Store
+	Keys      *jwt.KeyRing
+	Lockout   *lockout.Guard
+	Tokens    *onetime.Store
+	Mailer    mailer.Mailer
+	PublicURL string
 }
 
-func NewSettingsHandler(db *gorm.DB) *SettingsHandler {
-	return &SettingsHandler{DB: db}
+func NewSettingsHandler(db *gorm.DB, store *sessions.Store, keys *jwt.KeyRing, guard *lockout.Guard, tokens *onetime.Store, m mailer.Mailer, publicURL string) *SettingsHandler {
+	return &SettingsHandler{DB: db, Sessions: store, Keys: keys, Lockout: guard, Tokens: tokens, Mailer: m, PublicURL: publicURL}
 }
 
 func (sh *SettingsHandler) UpdateUserName(c *fiber.Ctx) error {
@@ -26,10 +41,13 @@ func (sh *SettingsHandler) UpdateUserName(c *fiber.Ctx) error {
 		})
 	}
 
-	userName := c.Query("username")
+	var req dto.UpdateUsernameRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
 
-	if userName == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "'username' parameter is required"})
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
 	}
 
 	var user models.User
@@ -38,16 +56,40 @@ func (sh *SettingsHandler) UpdateUserName(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "User not found (idk how)."})
 	}
 
+	if ok, err := sh.confirmSudo(c, claims, &user, req.CurrentPassword); !ok {
+		return err
+	}
+
 	// Search if user with the same username exists
 	var count int64
-	sh.DB.Model(&models.User{}).Where("username = ?", userName).Count(&count)
-	fmt.Printf("%d\n", count)
+	if err := sh.DB.Model(&models.User{}).Where("username = ?", req.Username).Count(&count).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update username"})
+	}
 	if count > 0 {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "User with the same username exists."})
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "User with the same username exists."})
 	}
 
-	user.Username = userName
-	if err := sh.DB.Save(&user).Error; err != nil {
+	// The old username keeps redirecting to the user's posts until someone
+	// else takes it.
+	old := models.UsernameHistory{UserID: user.ID, Username: user.Username, CreatedAt: time.Now()}
+	user.Username = req.Username
+	err = sh.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("username = ?", user.Username).Delete(&models.UsernameHistory{}).Error; err != nil {
+			return err
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "username"}},
+			DoUpdates: clause.AssignmentColumns([]string{"user_id", "created_at"}),
+		}).Create(&old).Error
+	})
+	if err != nil {
+		// Someone else took the username since the check above.
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "User with the same username exists."})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update username"})
 	}
 
@@ -60,9 +102,13 @@ func (sh *SettingsHandler) UpdateDisplayName(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	displayName := c.Query("displayName")
-	if displayName == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "'displayName' parameter is required"})
+	var req dto.UpdateDisplayNameRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
 	}
 
 	var user models.User
@@ -71,7 +117,7 @@ func (sh *SettingsHandler) UpdateDisplayName(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "User not found (idk how)."})
 	}
 
-	user.DisplayName = &displayName
+	user.DisplayName = &req.DisplayName
 	if err := sh.DB.Save(&user).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update display name"})
 	}
@@ -85,9 +131,13 @@ func (sh *SettingsHandler) UpdatePassword(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	newPassword := c.Query("password")
-	if newPassword == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "'password' parameter is required"})
+	var req dto.UpdatePasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
 	}
 
 	var user models.User
@@ -96,15 +146,28 @@ func (sh *SettingsHandler) UpdatePassword(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "User not found"})
 	}
 
-	hash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if ok, err := sh.confirmSudo(c, claims, &user, req.CurrentPassword); !ok {
+		return err
+	}
+
+	if err := password.Check(req.Password, user.Username, user.Email); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	hash, err := password.Hash(req.Password)
 	if err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
 	}
 
-	user.PasswordHash = string(hash)
+	user.PasswordHash = hash
 	if err := sh.DB.Save(&user).Error; err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to update password"})
 	}
 
+	// Sign out every other device, including their outstanding access tokens.
+	if err := sh.Sessions.RevokeOthers(user.ID, claims.SessionID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to revoke other sessions"})
+	}
+
 	return c.Status(fiber.StatusOK).JSON(fiber.Map{"success": 1})
 }
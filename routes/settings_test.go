@@ -0,0 +1,62 @@
+package routes
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/models"
+	"gorm.io/gorm"
+)
+
+// newTestSettingsHandler returns a SettingsHandler sharing the database and
+// stores of h.
+func newTestSettingsHandler(h *AuthHandler) *SettingsHandler {
+	return NewSettingsHandler(h.DB, h.Sessions, h.Keys, h.Lockout, h.Tokens, h.Mailer, testPublicURL)
+}
+
+func TestUpdateUserName(t *testing.T) {
+	h := newTestAuthHandler(t)
+	sh := newTestSettingsHandler(h)
+	user := createTestUser(t, h, "reader", "reader@example.com")
+	createTestUser(t, h, "taken", "taken@example.com")
+
+	app := fiber.New()
+	app.Post("/settings/update-username", signedIn(user.ID, 0), sh.UpdateUserName)
+
+	rename := func(username string) (int, map[string]any) {
+		return postJSON(t, app, "/settings/update-username", map[string]any{
+			"username":         username,
+			"current_password": "correct horse",
+		})
+	}
+
+	if status, body := rename("taken"); status != http.StatusConflict {
+		t.Fatalf("rename to a taken username = %d %v, want %d", status, body, http.StatusConflict)
+	}
+
+	// Another request takes the username between the check and the update.
+	var once sync.Once
+	sh.DB.Callback().Update().Before("gorm:update").Register("test:race", func(tx *gorm.DB) {
+		once.Do(func() {
+			rival := models.User{Username: "contested", Email: "rival@example.com", CreatedAt: time.Now()}
+			if err := tx.Session(&gorm.Session{NewDB: true}).Create(&rival).Error; err != nil {
+				t.Errorf("create rival: %v", err)
+			}
+		})
+	})
+	if status, body := rename("contested"); status != http.StatusConflict {
+		t.Fatalf("rename losing a race = %d %v, want %d", status, body, http.StatusConflict)
+	}
+
+	if status, body := rename("renamed"); status != http.StatusOK {
+		t.Fatalf("rename = %d %v", status, body)
+	}
+
+	var history models.UsernameHistory
+	if err := sh.DB.Where("username = ?", "reader").First(&history).Error; err != nil || history.UserID != user.ID {
+		t.Fatalf("old username history = %+v, %v", history, err)
+	}
+}
@@ -0,0 +1,89 @@
+package routes
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/dto"
+	"github.com/kostya-zero/blogger/helpers"
+	"github.com/kostya-zero/blogger/pat"
+	"github.com/kostya-zero/blogger/validation"
+	"gorm.io/gorm"
+)
+
+type TokensHandler struct {
+	Tokens *pat.Store
+}
+
+func NewTokensHandler(store *pat.Store) *TokensHandler {
+	return &TokensHandler{Tokens: store}
+}
+
+func (th *TokensHandler) Create(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var req dto.CreateTokenRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid payload"})
+	}
+
+	if err := validation.ValidateStruct(req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": (*err)[0]})
+	}
+
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	raw, token, err := th.Tokens.Create(claims.UserID, req.Name, req.Scopes, expiresAt)
+	if err != nil {
+		if errors.Is(err, pat.ErrUnknownScope) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Unknown scope", "scopes": pat.Scopes})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not create token"})
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"token": raw, "details": token})
+}
+
+func (th *TokensHandler) List(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	tokens, err := th.Tokens.List(claims.UserID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not retrieve tokens"})
+	}
+
+	return c.JSON(tokens)
+}
+
+func (th *TokensHandler) Revoke(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	tokenID, err := strconv.ParseUint(c.Query("id", ""), 10, 32)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The 'id' parameter is required"})
+	}
+
+	if err := th.Tokens.Revoke(claims.UserID, uint(tokenID)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Token not found"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not revoke token"})
+	}
+
+	return c.JSON(fiber.Map{"success": 1})
+}
@@ -4,7 +4,11 @@ import (
 	"errors"
 	"strconv"
 
+	"github.com/kostya-zero/blogger/helpers"
 	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/moderation"
+	"github.com/kostya-zero/blogger/publishing"
+	"github.com/kostya-zero/blogger/roles"
 
 	"github.com/gofiber/fiber/v2"
 	"gorm.io/gorm"
@@ -34,6 +38,14 @@ func (uh *UserHandler) GetUser(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not retrieve user data"})
 	}
 
+	// Only the user and role managers learn the role of an account.
+	if claims, err := helpers.GetClaimsFromContext(c); err == nil && (claims.UserID == user.ID || claims.HasPermission(roles.PermManageRoles)) {
+		return c.JSON(struct {
+			models.User
+			Role string `json:"role"`
+		}{user, user.Role})
+	}
+
 	return c.JSON(user)
 }
 
@@ -53,6 +65,14 @@ func (uh *UserHandler) GetUsersPosts(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not retrieve user data"})
 	}
 
+	err := uh.DB.
+		Where("user_id = ? AND status = ? AND user_id NOT IN (?)", user.ID, publishing.StatusPublished, moderation.HiddenAuthors(uh.DB)).
+		Order("published_at DESC NULLS LAST, created_at DESC").
+		Find(&user.Posts).Error
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "could not retrieve posts"})
+	}
+
 	if len(user.Posts) == 0 {
 		return c.JSON(fiber.Map{})
 	}
@@ -72,7 +92,9 @@ func (uh *UserHandler) GetLikes(c *fiber.Ctx) error {
 	}
 
 	var likes []models.Like
-	result := uh.DB.Preload("Post").Find(&likes, "user_id = ?", userID)
+	visiblePosts := uh.DB.Model(&models.Post{}).Select("id").
+		Where("status IN ? AND user_id NOT IN (?)", publishing.Public, moderation.HiddenAuthors(uh.DB))
+	result := uh.DB.Preload("Post").Find(&likes, "user_id = ? AND post_id IN (?)", userID, visiblePosts)
 	if result.Error != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
 	}
@@ -0,0 +1,105 @@
+package routes
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/jwt"
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/publishing"
+	"github.com/kostya-zero/blogger/roles"
+)
+
+func TestGetUserRole(t *testing.T) {
+	auth := newTestAuthHandler(t)
+	uh := NewUserHandler(auth.DB)
+	user := createTestUser(t, auth, "writer", "writer@example.com")
+	other := createTestUser(t, auth, "reader", "reader@example.com")
+
+	manager := jwt.NewClaims(other.ID, 0, time.Minute)
+	manager.Permissions = []string{roles.PermManageRoles}
+
+	for _, tt := range []struct {
+		name     string
+		viewer   *jwt.TokenClaims
+		wantRole bool
+	}{
+		{"anonymous", nil, false},
+		{"other user", jwt.NewClaims(other.ID, 0, time.Minute), false},
+		{"self", jwt.NewClaims(user.ID, 0, time.Minute), true},
+		{"role manager", manager, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Get("/users/get", func(c *fiber.Ctx) error {
+				if tt.viewer != nil {
+					c.Locals("user", tt.viewer)
+				}
+				return c.Next()
+			}, uh.GetUser)
+
+			resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/users/get?id=writer", nil), -1)
+			if err != nil {
+				t.Fatalf("GET /users/get: %v", err)
+			}
+			body := decode(t, resp)
+
+			role, ok := body["role"]
+			if ok != tt.wantRole {
+				t.Fatalf("role in response = %v, want %v (%v)", ok, tt.wantRole, body)
+			}
+			if ok && role != roles.RoleUser {
+				t.Fatalf("role = %v, want %q", role, roles.RoleUser)
+			}
+			if body["username"] != "writer" {
+				t.Fatalf("username = %v, want writer", body["username"])
+			}
+		})
+	}
+}
+
+func TestGetUsersPostsOrder(t *testing.T) {
+	auth := newTestAuthHandler(t)
+	uh := NewUserHandler(auth.DB)
+	user := createTestUser(t, auth, "writer", "writer@example.com")
+
+	now := time.Now()
+	older, newer := now.Add(-time.Hour), now
+	for _, post := range []models.Post{
+		// Not backfilled yet: no publish date, but the newest creation date.
+		{Title: "legacy", CreatedAt: now.Add(time.Hour)},
+		{Title: "older", CreatedAt: older, PublishedAt: &older},
+		{Title: "newer", CreatedAt: newer, PublishedAt: &newer},
+	} {
+		post.UserID = user.ID
+		post.Status = publishing.StatusPublished
+		if err := auth.DB.Create(&post).Error; err != nil {
+			t.Fatalf("create post: %v", err)
+		}
+	}
+
+	app := fiber.New()
+	app.Get("/users/getPosts", uh.GetUsersPosts)
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/users/getPosts?id=writer", nil), -1)
+	if err != nil {
+		t.Fatalf("GET /users/getPosts: %v", err)
+	}
+
+	var posts []models.Post
+	if err := json.NewDecoder(resp.Body).Decode(&posts); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+
+	var titles []string
+	for _, post := range posts {
+		titles = append(titles, post.Title)
+	}
+	if want := []string{"newer", "older", "legacy"}; !slices.Equal(titles, want) {
+		t.Fatalf("posts = %v, want %v", titles, want)
+	}
+}
@@ -0,0 +1,117 @@
+package routes
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kostya-zero/blogger/helpers"
+	"github.com/kostya-zero/blogger/mailer"
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/onetime"
+	"gorm.io/gorm"
+)
+
+const (
+	verificationTTL = 48 * time.Hour
+
+	// A new verification email can be requested once a minute and at most
+	// five times an hour.
+	resendInterval = time.Minute
+	resendPerHour  = 5
+)
+
+func (h *AuthHandler) sendVerification(user *models.User) error {
+	token, err := h.Tokens.Issue(user.ID, onetime.PurposeVerifyEmail, user.Email, verificationTTL)
+	if err != nil {
+		return err
+	}
+
+	return h.Mailer.Send(mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nconfirm your email address by opening this link:\n\n%s/auth/verify?token=%s\n\nThe link expires in 48 hours.\n",
+			user.Username, h.PublicURL, token),
+	})
+}
+
+func (h *AuthHandler) Verify(c *fiber.Ctx) error {
+	raw := c.Query("token", "")
+	if raw == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "The 'token' parameter is required"})
+	}
+
+	token, err := h.Tokens.Consume(raw, onetime.PurposeVerifyEmail)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired verification link"})
+	}
+
+	// The address may have changed since the link was sent.
+	result := h.DB.Model(&models.User{}).
+		Where("id = ? AND email = ?", token.UserID, token.Email).
+		Update("verified_at", time.Now())
+	if result.Error != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not verify email"})
+	}
+	if result.RowsAffected == 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid or expired verification link"})
+	}
+
+	return c.JSON(fiber.Map{"success": 1})
+}
+
+func (h *AuthHandler) ResendVerification(c *fiber.Ctx) error {
+	claims, err := helpers.GetClaimsFromContext(c)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	var user models.User
+	if err := h.DB.First(&user, claims.UserID).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "User not found"})
+	}
+
+	if user.VerifiedAt != nil {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Email is already verified"})
+	}
+
+	now := time.Now()
+	recent, err := h.Tokens.IssuedSince(user.ID, onetime.PurposeVerifyEmail, now.Add(-resendInterval))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+	}
+	hourly, err := h.Tokens.IssuedSince(user.ID, onetime.PurposeVerifyEmail, now.Add(-time.Hour))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error"})
+	}
+	if recent > 0 || hourly >= resendPerHour {
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "Too many verification emails requested, try again later"})
+	}
+
+	if err := h.sendVerification(&user); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Could not send verification email"})
+	}
+
+	return c.JSON(fiber.Map{"success": 1})
+}
+
+// RequireVerified rejects users who have not confirmed their email address.
+func RequireVerified(db *gorm.DB) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims, err := helpers.GetClaimsFromContext(c)
+		if err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+		}
+
+		var user models.User
+		if err := db.Select("id", "verified_at").First(&user, claims.UserID).Error; err != nil {
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Access denied."})
+		}
+
+		if user.VerifiedAt == nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "Confirm your email address first"})
+		}
+
+		return c.Next()
+	}
+}
@@ -0,0 +1,303 @@
+// Package sessions manages signed-in devices and their rotating refresh
+// tokens.
+package sessions
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/kostya-zero/blogger/helpers"
+	"github.com/kostya-zero/blogger/jwt"
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/revocation"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrNotFound = errors.New("refresh token not found")
+	ErrExpired  = errors.New("refresh token expired")
+	ErrRevoked  = errors.New("refresh token revoked")
+	ErrReused   = errors.New("refresh token reuse detected")
+
+	ErrSessionInactive = errors.New("session is revoked or expired")
+)
+
+// seenInterval limits how often LastSeenAt is written for active sessions.
+const seenInterval = time.Minute
+
+type Store struct {
+	DB      *gorm.DB
+	TTL     time.Duration
+	Revoked revocation.Store
+}
+
+// Device describes the client a session is created for.
+type Device struct {
+	Label     string
+	UserAgent string
+	IP        string
+}
+
+// Method values for the amr claim (RFC 8176).
+const (
+	MethodPassword = "pwd"
+	MethodOTP      = "otp"
+	MethodMFA      = "mfa"
+	MethodHardware = "hwk"
+
+	// Not registered by RFC 8176: sign-ins through an external OpenID
+	// Connect provider and through a link sent by email.
+	MethodOIDC      = "oidc"
+	MethodMagicLink = "email"
+)
+
+func NewStore(db *gorm.DB, ttl time.Duration, revoked revocation.Store) *Store {
+	return &Store{DB: db, TTL: ttl, Revoked: revoked}
+}
+
+// Create starts a new session for the user and returns it together with its
+// first refresh token. amr lists the methods the user authenticated with.
+func (s *Store) Create(userID uint, device Device, amr []string) (*models.Session, string, error) {
+	now := time.Now()
+	session := models.Session{
+		UserID:      userID,
+		DeviceLabel: device.Label,
+		UserAgent:   device.UserAgent,
+		IP:          device.IP,
+		AMR:         strings.Join(amr, " "),
+		AuthTime:    now,
+		CreatedAt:   now,
+		LastSeenAt:  now,
+		ExpiresAt:   now.Add(s.TTL),
+	}
+
+	var raw string
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return err
+		}
+
+		var err error
+		raw, err = s.createToken(tx, &session, nil)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &session, raw, nil
+}
+
+// Rotate exchanges a refresh token for the next one of its session. Each token
+// can be exchanged only once: presenting an already used token revokes the
+// whole session, since either the client or an attacker holds a stolen copy.
+func (s *Store) Rotate(raw string, ip string) (*models.Session, string, error) {
+	var (
+		next    string
+		current models.RefreshToken
+		session models.Session
+		reused  bool
+	)
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", helpers.HashToken(raw)).
+			First(&current).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		if err := tx.First(&session, current.SessionID).Error; err != nil {
+			return err
+		}
+
+		if current.RevokedAt != nil || session.RevokedAt != nil {
+			return ErrRevoked
+		}
+
+		if current.UsedAt != nil {
+			reused = true
+			return s.revoke(tx, "id = ?", session.ID)
+		}
+
+		now := time.Now()
+		if now.After(current.ExpiresAt) || now.After(session.ExpiresAt) {
+			return ErrExpired
+		}
+
+		if err := tx.Model(&current).Update("used_at", now).Error; err != nil {
+			return err
+		}
+
+		session.LastSeenAt = now
+		session.ExpiresAt = now.Add(s.TTL)
+		session.IP = ip
+		err = tx.Model(&session).Updates(map[string]any{
+			"last_seen_at": session.LastSeenAt,
+			"expires_at":   session.ExpiresAt,
+			"ip":           session.IP,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		next, err = s.createToken(tx, &session, &current.ID)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	if reused {
+		return &session, "", ErrReused
+	}
+
+	return &session, next, nil
+}
+
+// SetAccessToken ties a freshly issued access token to its session, so that
+// revoking the session revokes the token as well.
+func (s *Store) SetAccessToken(claims *jwt.TokenClaims) error {
+	return s.DB.Model(&models.Session{}).Where("id = ?", claims.SessionID).Updates(map[string]any{
+		"last_jti":    claims.ID,
+		"jti_expires": claims.ExpiresAt.Time,
+	}).Error
+}
+
+// FindByRefreshToken returns the session a refresh token was issued for.
+func (s *Store) FindByRefreshToken(raw string) (*models.Session, error) {
+	var token models.RefreshToken
+	if err := s.DB.Preload("Session").Where("token_hash = ?", helpers.HashToken(raw)).First(&token).Error; err != nil {
+		return nil, err
+	}
+
+	return &token.Session, nil
+}
+
+// List returns active sessions of the user, most recently used first.
+func (s *Store) List(userID uint) ([]models.Session, error) {
+	var sessions []models.Session
+	err := s.DB.
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// Revoke revokes a single session of the user.
+func (s *Store) Revoke(userID, sessionID uint) error {
+	var session models.Session
+	if err := s.DB.Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).First(&session).Error; err != nil {
+		return err
+	}
+
+	return s.revoke(s.DB, "id = ?", session.ID)
+}
+
+// RevokeOthers revokes every session of the user except the given one.
+func (s *Store) RevokeOthers(userID, keepID uint) error {
+	return s.revoke(s.DB, "user_id = ? AND id <> ?", userID, keepID)
+}
+
+// RevokeUser revokes every session of the user.
+func (s *Store) RevokeUser(userID uint) error {
+	return s.revoke(s.DB, "user_id = ?", userID)
+}
+
+// Check is a jwt.Check that rejects access tokens of revoked or expired
+// sessions. Impersonation tokens live as long as the session of the actor.
+// Legacy tokens predate sessions; the key ring alone decides how long they
+// are accepted.
+func (s *Store) Check(claims *jwt.TokenClaims) error {
+	if claims.Legacy {
+		return nil
+	}
+
+	owner := claims.UserID
+	if claims.Impersonated() {
+		owner = claims.Act.UserID
+	}
+
+	var session models.Session
+	if err := s.DB.Select("id", "user_id", "expires_at", "revoked_at", "last_seen_at").First(&session, claims.SessionID).Error; err != nil {
+		return ErrSessionInactive
+	}
+
+	now := time.Now()
+	if session.UserID != owner || session.RevokedAt != nil || now.After(session.ExpiresAt) {
+		return ErrSessionInactive
+	}
+
+	if now.Sub(session.LastSeenAt) > seenInterval {
+		s.DB.Model(&session).Update("last_seen_at", now)
+	}
+
+	return nil
+}
+
+func (s *Store) createToken(db *gorm.DB, session *models.Session, parentID *uint) (string, error) {
+	raw, err := helpers.RandomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	token := models.RefreshToken{
+		UserID:    session.UserID,
+		SessionID: session.ID,
+		ParentID:  parentID,
+		TokenHash: helpers.HashToken(raw),
+		ExpiresAt: session.ExpiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	if err := db.Create(&token).Error; err != nil {
+		return "", err
+	}
+
+	return raw, nil
+}
+
+// revoke revokes the matching sessions, their refresh tokens and their
+// latest access tokens.
+func (s *Store) revoke(db *gorm.DB, query string, args ...any) error {
+	var active []models.Session
+	if err := db.Where(query, args...).Where("revoked_at IS NULL").Find(&active).Error; err != nil {
+		return err
+	}
+
+	if len(active) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, 0, len(active))
+	for _, session := range active {
+		ids = append(ids, session.ID)
+	}
+
+	now := time.Now()
+	if err := db.Model(&models.RefreshToken{}).
+		Where("session_id IN ? AND revoked_at IS NULL", ids).
+		Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+
+	if err := db.Model(&models.Session{}).Where("id IN ?", ids).Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+
+	for _, session := range active {
+		if session.LastJTI == "" || session.JTIExpires == nil || now.After(*session.JTIExpires) {
+			continue
+		}
+		if err := s.Revoked.Revoke(session.LastJTI, *session.JTIExpires); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package sessions
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	gojwt "github.com/golang-jwt/jwt/v5"
+	"github.com/kostya-zero/blogger/dbtest"
+	"github.com/kostya-zero/blogger/jwt"
+	"github.com/kostya-zero/blogger/models"
+	"github.com/kostya-zero/blogger/revocation"
+	"gorm.io/gorm"
+)
+
+const legacySecret = "baseline secret"
+
+func newTestStore(t *testing.T) (*Store, *gorm.DB) {
+	t.Helper()
+
+	db := dbtest.Open(t)
+	return NewStore(db, time.Hour, revocation.NewMemoryStore(time.Minute)), db
+}
+
+// createUser adds a user for sessions to belong to.
+func createUser(t *testing.T, db *gorm.DB) uint {
+	t.Helper()
+
+	user := models.User{Username: "reader", Email: "reader@example.com", CreatedAt: time.Now()}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return user.ID
+}
+
+// legacyToken signs claims the way the server did before sessions: HS256
+// with a shared secret and no sid.
+func legacyToken(t *testing.T, userID uint, issuedAt time.Time) string {
+	t.Helper()
+
+	token, err := gojwt.NewWithClaims(gojwt.SigningMethodHS256, gojwt.MapClaims{
+		"sub": userID,
+		"iss": "blogger",
+		"exp": issuedAt.Add(15 * time.Minute).Unix(),
+		"iat": issuedAt.Unix(),
+		"nbf": issuedAt.Unix(),
+		"jti": "legacy-jti",
+	}).SignedString([]byte(legacySecret))
+	if err != nil {
+		t.Fatalf("sign legacy token: %v", err)
+	}
+	return token
+}
+
+func TestLegacyToken(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		legacyUntil time.Time
+		issuedAt    time.Time
+		want        int
+	}{
+		{"before cutoff", time.Now().Add(time.Hour), time.Now(), http.StatusOK},
+		{"after cutoff", time.Now().Add(-time.Minute), time.Now().Add(-5 * time.Minute), http.StatusUnauthorized},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			store, db := newTestStore(t)
+			keys, err := jwt.NewKeyRing(jwt.NewDBKeyStore(db), jwt.AlgorithmEdDSA, legacySecret, tt.legacyUntil)
+			if err != nil {
+				t.Fatalf("key ring: %v", err)
+			}
+
+			app := fiber.New()
+			app.Get("/", jwt.JwtMiddleware(keys, store.Check), func(c *fiber.Ctx) error {
+				return c.SendStatus(fiber.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set(fiber.HeaderAuthorization, "Bearer "+legacyToken(t, 1, tt.issuedAt))
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatalf("GET /: %v", err)
+			}
+			if resp.StatusCode != tt.want {
+				t.Fatalf("legacy token = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestRotate(t *testing.T) {
+	store, db := newTestStore(t)
+
+	session, first, err := store.Create(createUser(t, db), Device{Label: "laptop"}, []string{MethodPassword})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	rotated, second, err := store.Rotate(first, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if rotated.ID != session.ID || second == "" || second == first {
+		t.Fatalf("rotate = session %d, token %q, want the same session and a new token", rotated.ID, second)
+	}
+
+	if _, third, err := store.Rotate(second, "127.0.0.1"); err != nil || third == "" {
+		t.Fatalf("rotate next token = %q, %v", third, err)
+	}
+}
+
+func TestRotateReuse(t *testing.T) {
+	store, db := newTestStore(t)
+
+	userID := createUser(t, db)
+	session, first, err := store.Create(userID, Device{Label: "laptop"}, []string{MethodPassword})
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	_, second, err := store.Rotate(first, "127.0.0.1")
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+
+	if _, _, err := store.Rotate(first, "127.0.0.1"); !errors.Is(err, ErrReused) {
+		t.Fatalf("rotate used token = %v, want %v", err, ErrReused)
+	}
+
+	// The whole session is gone, including the token the client got last.
+	if _, _, err := store.Rotate(second, "127.0.0.1"); !errors.Is(err, ErrRevoked) {
+		t.Fatalf("rotate latest token = %v, want %v", err, ErrRevoked)
+	}
+
+	var revoked models.Session
+	if err := db.First(&revoked, session.ID).Error; err != nil {
+		t.Fatalf("load session: %v", err)
+	}
+	if revoked.RevokedAt == nil {
+		t.Fatal("session is not revoked")
+	}
+
+	var active int64
+	db.Model(&models.RefreshToken{}).Where("session_id = ? AND revoked_at IS NULL", session.ID).Count(&active)
+	if active != 0 {
+		t.Fatalf("%d refresh tokens still active", active)
+	}
+
+	if err := store.Check(jwt.NewClaims(userID, session.ID, time.Minute)); !errors.Is(err, ErrSessionInactive) {
+		t.Fatalf("check access token = %v, want %v", err, ErrSessionInactive)
+	}
+}
+
+func TestRotateUnknownToken(t *testing.T) {
+	store, _ := newTestStore(t)
+
+	if _, _, err := store.Rotate("not a token", "127.0.0.1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("rotate = %v, want %v", err, ErrNotFound)
+	}
+}
@@ -0,0 +1,189 @@
+// Package slugs turns post titles into readable URL slugs that are unique
+// among the posts of their author.
+package slugs
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/kostya-zero/blogger/models"
+	"golang.org/x/text/unicode/norm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// MaxLength limits the length of a slug before a collision suffix.
+const MaxLength = 80
+
+// fallback is the slug of titles that have no letters or digits to keep.
+const fallback = "post"
+
+// transliterations spells letters that do not decompose to Latin ones. An
+// empty spelling drops the character without breaking the word.
+var transliterations = map[rune]string{
+	// Cyrillic
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	'є': "ye", 'і': "i", 'ї': "yi", 'ґ': "g", 'ў': "u",
+
+	// Greek
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i",
+	'θ': "th", 'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x",
+	'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t", 'υ': "y",
+	'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o",
+
+	// Latin letters without a decomposition
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'đ': "d", 'ð': "d",
+	'ł': "l", 'þ': "th", 'ı': "i",
+
+	// Apostrophes join the parts of a word
+	'\'': "", '’': "",
+}
+
+// Make builds a slug from a title: letters are transliterated to ASCII,
+// lowercased, and every run of other characters becomes a single hyphen.
+func Make(title string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(norm.NFC.String(title)) {
+		part, word := spell(r)
+		if !word {
+			hyphen = b.Len() > 0
+			continue
+		}
+		if part == "" {
+			continue
+		}
+
+		if hyphen {
+			b.WriteByte('-')
+			hyphen = false
+		}
+		b.WriteString(part)
+	}
+
+	slug := b.String()
+	if len(slug) > MaxLength {
+		slug = slug[:MaxLength]
+		if cut := strings.LastIndexByte(slug, '-'); cut > MaxLength/2 {
+			slug = slug[:cut]
+		}
+		slug = strings.TrimRight(slug, "-")
+	}
+	if slug == "" {
+		return fallback
+	}
+	return slug
+}
+
+// Assign gives the post a slug made from its current title, adding a numeric
+// suffix when another post of the author has or had it. A slug the post had
+// before is kept in the history so links to it keep working. It must run in a
+// transaction; it locks the author's row so that posts of the same author get
+// their slugs one at a time. The caller saves the slug column.
+func Assign(tx *gorm.DB, post *models.Post) error {
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id").First(&models.User{}, post.UserID).Error
+	if err != nil {
+		return err
+	}
+
+	base := Make(post.Title)
+	if post.Slug != nil && (*post.Slug == base || strings.HasPrefix(*post.Slug, base+"-") && isSuffix((*post.Slug)[len(base)+1:])) {
+		return nil
+	}
+
+	like := base + "-%"
+	var taken []string
+	err = tx.Unscoped().Model(&models.Post{}).
+		Where("user_id = ? AND id <> ? AND (slug = ? OR slug LIKE ?)", post.UserID, post.ID, base, like).
+		Pluck("slug", &taken).Error
+	if err != nil {
+		return err
+	}
+
+	var old []string
+	err = tx.Model(&models.PostSlugHistory{}).
+		Where("user_id = ? AND post_id <> ? AND (slug = ? OR slug LIKE ?)", post.UserID, post.ID, base, like).
+		Pluck("slug", &old).Error
+	if err != nil {
+		return err
+	}
+	taken = append(taken, old...)
+
+	slug := base
+	for n := 2; slices.Contains(taken, slug); n++ {
+		slug = base + "-" + strconv.Itoa(n)
+	}
+
+	if post.Slug != nil && post.ID != 0 {
+		previous := models.PostSlugHistory{PostID: post.ID, UserID: post.UserID, Slug: *post.Slug}
+		if err := tx.Create(&previous).Error; err != nil {
+			return err
+		}
+		// The post may get back a slug it had before.
+		if err := tx.Where("post_id = ? AND slug = ?", post.ID, slug).Delete(&models.PostSlugHistory{}).Error; err != nil {
+			return err
+		}
+	}
+
+	post.Slug = &slug
+	return nil
+}
+
+// Backfill gives slugs to posts created before slugs existed.
+func Backfill(db *gorm.DB) error {
+	for {
+		var posts []models.Post
+		err := db.Unscoped().Select("id", "user_id", "title").Where("slug IS NULL").Limit(100).Find(&posts).Error
+		if err != nil || len(posts) == 0 {
+			return err
+		}
+
+		for i := range posts {
+			err := db.Transaction(func(tx *gorm.DB) error {
+				if err := Assign(tx, &posts[i]); err != nil {
+					return err
+				}
+				return tx.Unscoped().Model(&posts[i]).Update("slug", posts[i].Slug).Error
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// spell returns the ASCII spelling of a lowercase rune and whether it is part
+// of a word. Letters with diacritics lose them.
+func spell(r rune) (string, bool) {
+	if part, ok := transliterations[r]; ok {
+		return part, true
+	}
+	if r <= unicode.MaxASCII {
+		return string(r), unicode.IsLetter(r) || unicode.IsDigit(r)
+	}
+	if unicode.Is(unicode.Mn, r) {
+		return "", true
+	}
+
+	var b strings.Builder
+	for _, d := range norm.NFKD.String(string(r)) {
+		if part, ok := transliterations[d]; ok {
+			b.WriteString(part)
+		} else if d <= unicode.MaxASCII && (unicode.IsLetter(d) || unicode.IsDigit(d)) {
+			b.WriteRune(unicode.ToLower(d))
+		}
+	}
+	return b.String(), b.Len() > 0
+}
+
+// isSuffix reports whether s is a collision suffix Assign adds.
+func isSuffix(s string) bool {
+	n, err := strconv.Atoi(s)
+	return err == nil && n >= 2 && strconv.Itoa(n) == s
+}
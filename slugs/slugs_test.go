@@ -0,0 +1,181 @@
+package slugs
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kostya-zero/blogger/dbtest"
+	"github.com/kostya-zero/blogger/models"
+	"gorm.io/gorm"
+)
+
+func TestMake(t *testing.T) {
+	for _, tt := range []struct {
+		title, want string
+	}{
+		{"Hello, World!", "hello-world"},
+		{"  Go 1.25 released  ", "go-1-25-released"},
+		{"Привет, мир", "privet-mir"},
+		{"Щука и ёж", "shchuka-i-ezh"},
+		{"Καλημέρα κόσμε", "kalimera-kosme"},
+		{"Straße über Łódź", "strasse-uber-lodz"},
+		{"Crème brûlée", "creme-brulee"},
+		{"Don't panic", "dont-panic"},
+		{"Don’t panic", "dont-panic"},
+		{"!!! ???", fallback},
+		{"", fallback},
+		{"🙂🙂🙂", fallback},
+		{"— 2026 —", "2026"},
+	} {
+		if got := Make(tt.title); got != tt.want {
+			t.Errorf("Make(%q) = %q, want %q", tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestMakeLength(t *testing.T) {
+	word := strings.Repeat("a", 30)
+	slug := Make(strings.Repeat(word+" ", 5))
+
+	// Cut at the last word that fits rather than mid-word.
+	if want := word + "-" + word; slug != want {
+		t.Fatalf("Make = %q, want %q", slug, want)
+	}
+
+	if slug := Make(strings.Repeat("b", 200)); len(slug) != MaxLength {
+		t.Fatalf("Make of one long word has length %d, want %d", len(slug), MaxLength)
+	}
+}
+
+func newTestDB(t *testing.T) (*gorm.DB, uint) {
+	t.Helper()
+
+	db := dbtest.Open(t)
+	user := models.User{Username: "writer", Email: "writer@example.com", CreatedAt: time.Now()}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return db, user.ID
+}
+
+// create saves a post with a slug assigned from its title.
+func create(t *testing.T, db *gorm.DB, userID uint, title string) *models.Post {
+	t.Helper()
+
+	post := models.Post{UserID: userID, Title: title, Description: title, Content: title, CreatedAt: time.Now()}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := Assign(tx, &post); err != nil {
+			return err
+		}
+		return tx.Create(&post).Error
+	})
+	if err != nil {
+		t.Fatalf("create post: %v", err)
+	}
+	return &post
+}
+
+// retitle changes the title of the post and reassigns its slug.
+func retitle(t *testing.T, db *gorm.DB, post *models.Post, title string) {
+	t.Helper()
+
+	post.Title = title
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := Assign(tx, post); err != nil {
+			return err
+		}
+		return tx.Model(post).Updates(map[string]any{"title": title, "slug": post.Slug}).Error
+	})
+	if err != nil {
+		t.Fatalf("retitle post: %v", err)
+	}
+}
+
+func TestAssignCollisions(t *testing.T) {
+	db, userID := newTestDB(t)
+
+	first := create(t, db, userID, "Hello")
+	if *first.Slug != "hello" {
+		t.Fatalf("first slug = %q, want hello", *first.Slug)
+	}
+
+	// A post in the trash keeps its slug, since it may be restored.
+	if err := db.Delete(first).Error; err != nil {
+		t.Fatalf("trash post: %v", err)
+	}
+	second := create(t, db, userID, "Hello!")
+	if *second.Slug != "hello-2" {
+		t.Fatalf("slug next to a trashed post = %q, want hello-2", *second.Slug)
+	}
+
+	// A slug that redirects to another post is taken as well.
+	retitle(t, db, second, "Goodbye")
+	if *second.Slug != "goodbye" {
+		t.Fatalf("retitled slug = %q, want goodbye", *second.Slug)
+	}
+	third := create(t, db, userID, "Hello")
+	if *third.Slug != "hello-3" {
+		t.Fatalf("slug next to an old slug = %q, want hello-3", *third.Slug)
+	}
+
+	// Other authors have their own slugs.
+	other := models.User{Username: "reader", Email: "reader@example.com", CreatedAt: time.Now()}
+	if err := db.Create(&other).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	if post := create(t, db, other.ID, "Hello"); *post.Slug != "hello" {
+		t.Fatalf("slug of another author = %q, want hello", *post.Slug)
+	}
+}
+
+func TestAssignKeepsHistory(t *testing.T) {
+	db, userID := newTestDB(t)
+	post := create(t, db, userID, "Draft title")
+
+	// Retitling to the same slug changes nothing.
+	retitle(t, db, post, "Draft Title!")
+	if *post.Slug != "draft-title" {
+		t.Fatalf("slug = %q, want draft-title", *post.Slug)
+	}
+
+	retitle(t, db, post, "Final title")
+	if *post.Slug != "final-title" {
+		t.Fatalf("slug = %q, want final-title", *post.Slug)
+	}
+
+	var history []string
+	db.Model(&models.PostSlugHistory{}).Where("post_id = ?", post.ID).Pluck("slug", &history)
+	if len(history) != 1 || history[0] != "draft-title" {
+		t.Fatalf("history = %v, want [draft-title]", history)
+	}
+
+	// Going back takes the old slug out of the history.
+	retitle(t, db, post, "Draft title")
+	history = nil
+	db.Model(&models.PostSlugHistory{}).Where("post_id = ?", post.ID).Pluck("slug", &history)
+	if *post.Slug != "draft-title" || len(history) != 1 || history[0] != "final-title" {
+		t.Fatalf("slug = %q with history %v, want draft-title with [final-title]", *post.Slug, history)
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	db, userID := newTestDB(t)
+
+	for _, title := range []string{"Same", "Same", "Other"} {
+		post := models.Post{UserID: userID, Title: title, Description: title, Content: title, CreatedAt: time.Now()}
+		if err := db.Create(&post).Error; err != nil {
+			t.Fatalf("create post: %v", err)
+		}
+	}
+
+	if err := Backfill(db); err != nil {
+		t.Fatalf("backfill: %v", err)
+	}
+
+	var slugs []string
+	db.Model(&models.Post{}).Order("id").Pluck("slug", &slugs)
+	if want := []string{"same", "same-2", "other"}; strings.Join(slugs, " ") != strings.Join(want, " ") {
+		t.Fatalf("slugs = %v, want %v", slugs, want)
+	}
+}
@@ -0,0 +1,89 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of the RFC 6238 test vectors,
+// "12345678901234567890", in base32.
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeRFC6238(t *testing.T) {
+	// RFC 6238 Appendix B, SHA-1, truncated to six digits.
+	for _, tt := range []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	} {
+		got, err := Code(rfcSecret, tt.unix/period)
+		if err != nil {
+			t.Fatalf("Code at %d: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCodeLowercaseSecret(t *testing.T) {
+	upper, _ := Code(rfcSecret, 1)
+	lower, err := Code("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 1)
+	if err != nil || lower != upper {
+		t.Fatalf("Code with lowercase secret = %s, %v, want %s", lower, err, upper)
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / period
+
+	for _, tt := range []struct {
+		name    string
+		counter int64
+		ok      bool
+	}{
+		{"two periods early", current - 2, false},
+		{"one period early", current - 1, true},
+		{"current period", current, true},
+		{"one period late", current + 1, true},
+		{"two periods late", current + 2, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _ := Code(rfcSecret, tt.counter)
+
+			counter, ok := Validate(code, rfcSecret, now, 0)
+			if ok != tt.ok {
+				t.Fatalf("Validate = %v, want %v", ok, tt.ok)
+			}
+			if ok && counter != tt.counter {
+				t.Fatalf("Validate counter = %d, want %d", counter, tt.counter)
+			}
+		})
+	}
+}
+
+func TestValidateLastCounter(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / period
+	code, _ := Code(rfcSecret, current)
+
+	if _, ok := Validate(code, rfcSecret, now, current); ok {
+		t.Fatal("Validate accepted a code of the last used period")
+	}
+	if _, ok := Validate(code, rfcSecret, now, current-1); !ok {
+		t.Fatal("Validate rejected a code after the last used period")
+	}
+}
+
+func TestValidateWrongCode(t *testing.T) {
+	if _, ok := Validate("000000", rfcSecret, time.Unix(1111111111, 0), 0); ok {
+		t.Fatal("Validate accepted a wrong code")
+	}
+}
@@ -0,0 +1,84 @@
+// Package trash permanently removes posts that stayed deleted for longer than
+// the retention period.
+package trash
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/kostya-zero/blogger/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// batchSize limits how many posts a single purge transaction removes.
+const batchSize = 500
+
+type Purger struct {
+	DB        *gorm.DB
+	Retention time.Duration
+}
+
+// NewPurger creates a purger that runs every interval.
+func NewPurger(db *gorm.DB, retention, interval time.Duration) *Purger {
+	p := &Purger{DB: db, Retention: retention}
+	go p.purgeLoop(interval)
+	return p
+}
+
+// PurgeAt returns when a post deleted at deletedAt will be removed for good.
+func (p *Purger) PurgeAt(deletedAt time.Time) time.Time {
+	return deletedAt.Add(p.Retention)
+}
+
+// Purge removes posts deleted before the retention period together with
+// their likes, revisions and old slugs, and returns how many posts it removed.
+func (p *Purger) Purge() (int, error) {
+	cutoff := time.Now().Add(-p.Retention)
+	total := 0
+	for {
+		var ids []uint
+		err := p.DB.Transaction(func(tx *gorm.DB) error {
+			// Posts being restored are skipped; the lock keeps the rest
+			// from being restored until they are gone.
+			err := tx.Unscoped().Model(&models.Post{}).
+				Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+				Where("deleted_at IS NOT NULL AND deleted_at < ?", cutoff).
+				Limit(batchSize).
+				Pluck("id", &ids).Error
+			if err != nil || len(ids) == 0 {
+				return err
+			}
+
+			if err := tx.Where("post_id IN ?", ids).Delete(&models.Like{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("post_id IN ?", ids).Delete(&models.PostRevision{}).Error; err != nil {
+				return err
+			}
+			if err := tx.Where("post_id IN ?", ids).Delete(&models.PostSlugHistory{}).Error; err != nil {
+				return err
+			}
+			return tx.Unscoped().Delete(&models.Post{}, ids).Error
+		})
+		if err != nil {
+			return total, err
+		}
+
+		total += len(ids)
+		if len(ids) < batchSize {
+			return total, nil
+		}
+	}
+}
+
+func (p *Purger) purgeLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if _, err := p.Purge(); err != nil {
+			fmt.Printf("Failed to purge deleted posts: %s\n", err.Error())
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package trash
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/kostya-zero/blogger/dbtest"
+	"github.com/kostya-zero/blogger/models"
+	"gorm.io/gorm"
+)
+
+const retention = 30 * 24 * time.Hour
+
+// createPost adds a post with a like, a revision and an old slug, deleted at
+// deletedAt unless it is zero.
+func createPost(t *testing.T, db *gorm.DB, userID uint, title string, deletedAt time.Time) uint {
+	t.Helper()
+
+	post := models.Post{UserID: userID, Title: title, Description: title, Content: title, CreatedAt: time.Now()}
+	if err := db.Create(&post).Error; err != nil {
+		t.Fatalf("create post: %v", err)
+	}
+
+	for _, row := range []any{
+		&models.Like{PostID: post.ID, UserID: userID},
+		&models.PostRevision{PostID: post.ID, Number: 1, Title: title, Description: title, Content: title, EditorID: userID, CreatedAt: time.Now()},
+		&models.PostSlugHistory{PostID: post.ID, UserID: userID, Slug: "old-" + title, CreatedAt: time.Now()},
+	} {
+		if err := db.Create(row).Error; err != nil {
+			t.Fatalf("create %T: %v", row, err)
+		}
+	}
+
+	if !deletedAt.IsZero() {
+		if err := db.Model(&post).Update("deleted_at", deletedAt).Error; err != nil {
+			t.Fatalf("delete post: %v", err)
+		}
+	}
+	return post.ID
+}
+
+// remaining counts the rows of the model that belong to the post.
+func remaining(t *testing.T, db *gorm.DB, model any, postID uint) int64 {
+	t.Helper()
+
+	column := "post_id"
+	if _, ok := model.(*models.Post); ok {
+		column = "id"
+	}
+
+	var count int64
+	if err := db.Unscoped().Model(model).Where(fmt.Sprintf("%s = ?", column), postID).Count(&count).Error; err != nil {
+		t.Fatalf("count %T: %v", model, err)
+	}
+	return count
+}
+
+func TestPurge(t *testing.T) {
+	db := dbtest.Open(t)
+	user := models.User{Username: "reader", Email: "reader@example.com", CreatedAt: time.Now()}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	now := time.Now()
+	expired := createPost(t, db, user.ID, "expired", now.Add(-retention-time.Hour))
+	recent := createPost(t, db, user.ID, "recent", now.Add(-retention+time.Hour))
+	live := createPost(t, db, user.ID, "live", time.Time{})
+
+	p := &Purger{DB: db, Retention: retention}
+	purged, err := p.Purge()
+	if err != nil {
+		t.Fatalf("purge: %v", err)
+	}
+	if purged != 1 {
+		t.Fatalf("purged %d posts, want 1", purged)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		postID uint
+		want   int64
+	}{
+		{"expired", expired, 0},
+		{"recent", recent, 1},
+		{"live", live, 1},
+	} {
+		for _, model := range []any{&models.Post{}, &models.Like{}, &models.PostRevision{}, &models.PostSlugHistory{}} {
+			if got := remaining(t, db, model, tt.postID); got != tt.want {
+				t.Errorf("%s post: %d %T rows left, want %d", tt.name, got, model, tt.want)
+			}
+		}
+	}
+
+	// Nothing is left to purge.
+	if purged, err := p.Purge(); err != nil || purged != 0 {
+		t.Fatalf("second purge = %d, %v, want 0", purged, err)
+	}
+}